@@ -1,17 +1,93 @@
 package build
 
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-webhook/configuration"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+)
+
 // Service provide necessary services for f8-build needed by webhook
 type Service interface {
+	// GetEnvironmentType returns OSD for the repositories
+	// of OSD tenants, OSIO otherwise
 	GetEnvironmentType(giturl string) (string, error)
+	// GetTenant returns the tenant owning the repository,
+	// empty if it is unknown
+	GetTenant(giturl string) (string, error)
+}
+
+// serviceConfiguration the Configuration needed by the build service
+type serviceConfiguration interface {
+	GetTenants() (map[string]configuration.Tenant, error)
 }
 
-type service struct{}
+type service struct {
+	tenants map[string]configuration.Tenant
+}
 
 // New gives an instance of build service
-func New() Service {
-	return &service{}
+func New(config serviceConfiguration) (Service, error) {
+	tenants, err := config.GetTenants()
+	if err != nil {
+		return nil, err
+	}
+	for name, t := range tenants {
+		switch strings.ToUpper(t.Environment) {
+		case "", "OSIO", "OSD":
+		default:
+			return nil, fmt.Errorf("tenant %s: invalid environment %q", name, t.Environment)
+		}
+		for _, r := range t.Repositories {
+			// path.Match only reports malformed patterns while matching
+			if _, err := path.Match(r, ""); err != nil {
+				return nil, fmt.Errorf("tenant %s: invalid repository pattern %q: %v", name, r, err)
+			}
+		}
+	}
+	return &service{tenants: tenants}, nil
 }
 
-func (*service) GetEnvironmentType(giturl string) (string, error) {
+func (s *service) GetEnvironmentType(giturl string) (string, error) {
+	if _, t := s.match(giturl); strings.ToUpper(t.Environment) == "OSD" {
+		return "OSD", nil
+	}
 	return "OSIO", nil
 }
+
+func (s *service) GetTenant(giturl string) (string, error) {
+	tenant, _ := s.match(giturl)
+	return strings.ToLower(tenant), nil
+}
+
+// match returns the tenant with the most specific pattern
+// matching the repository, the first one by name on ties
+func (s *service) match(giturl string) (string, configuration.Tenant) {
+	repo := routing.RepositoryPath(giturl)
+	tenant, best := "", -1
+	for name, t := range s.tenants {
+		for _, r := range t.Repositories {
+			if ok, _ := path.Match(r, repo); !ok {
+				continue
+			}
+			score := literalLen(r)
+			if score > best || score == best && name < tenant {
+				tenant, best = name, score
+			}
+		}
+	}
+	return tenant, s.tenants[tenant]
+}
+
+// literalLen is the length of pattern without wildcards
+func literalLen(pattern string) int {
+	n := 0
+	for _, c := range pattern {
+		if !strings.ContainsRune(`*?[]\`, c) {
+			n++
+		}
+	}
+	return n
+}
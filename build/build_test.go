@@ -0,0 +1,54 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/fabric8-services/fabric8-webhook/configuration"
+)
+
+type config struct {
+	tenants map[string]configuration.Tenant
+}
+
+func (c *config) GetTenants() (map[string]configuration.Tenant, error) {
+	return c.tenants, nil
+}
+
+func Test_service_GetTenant(t *testing.T) {
+	s, err := New(&config{
+		tenants: map[string]configuration.Tenant{
+			"alice": {Repositories: []string{"github.com/org/*"}},
+			"bob":   {Repositories: []string{"github.com/org/app", "gitlab.com/bob/*"}, Environment: "osd"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		gitURL  string
+		want    string
+		wantEnv string
+	}{
+		{name: "Org Pattern", gitURL: "https://github.com/org/lib.git", want: "alice", wantEnv: "OSIO"},
+		{name: "Most Specific Pattern", gitURL: "git@github.com:org/app.git", want: "bob", wantEnv: "OSD"},
+		{name: "Unknown", gitURL: "https://github.com/other/app", want: "", wantEnv: "OSIO"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := s.GetTenant(tt.gitURL); got != tt.want {
+				t.Errorf("service.GetTenant() = %v, want %v", got, tt.want)
+			}
+			if got, _ := s.GetEnvironmentType(tt.gitURL); got != tt.wantEnv {
+				t.Errorf("service.GetEnvironmentType() = %v, want %v", got, tt.wantEnv)
+			}
+		})
+	}
+
+	if _, err := New(&config{tenants: map[string]configuration.Tenant{"alice": {Repositories: []string{"github.com/["}}}}); err == nil {
+		t.Errorf("New() with malformed pattern error = nil, want an error")
+	}
+	if _, err := New(&config{tenants: map[string]configuration.Tenant{"alice": {Environment: "OSO"}}}); err == nil {
+		t.Errorf("New() with unknown environment error = nil, want an error")
+	}
+}
@@ -1,6 +1,7 @@
 package configuration
 
 import (
+	"fmt"
 	"os"
 	"strings"
 	"time"
@@ -38,8 +39,152 @@ const (
 
 	// ProxyURL
 	varProxyURL = "proxy.url"
+
+	// Gitea
+	varGiteaSecret = "gitea.secret"
+
+	// Azure DevOps
+	varAzureUsername = "azure.username"
+	varAzurePassword = "azure.password"
+
+	// Container registry
+	varRegistryToken  = "registry.token"
+	varRegistrySecret = "registry.secret"
+	varRegistryRules  = "registry.rules"
+
+	// GitHub Enterprise Server instances
+	varGitHubInstances = "github.instances"
+
+	// Generic webhook sources
+	varGenericSources = "generic.sources"
+
+	// Routing
+	varRoutingFile           = "routing.file"
+	varRoutingReloadDuration = "routing.reload.duration"
+	// varRoutingStorage reads the routing table from the storage backend
+	varRoutingStorage = "routing.storage"
+
+	// Tenants owning repositories
+	varTenants = "tenants"
+
+	// OpenShift Dedicated
+	varOSDClusters = "osd.clusters"
+	varOSDTenants  = "osd.tenants"
+
+	// Forwarding
+	varForwardAsync     = "forward.async"
+	varForwardQueueSize = "forward.queue.size"
+	varForwardWorkers   = "forward.workers"
+	// varForwardSyncTimeout caps forwarding in synchronous mode, the
+	// sender waits for it e.g. GitHub gives up after 10s. Retries and
+	// wake-ups which would take longer are not waited for, deliveries
+	// needing them must be forwarded in asynchronous mode.
+	varForwardSyncTimeout = "forward.sync_timeout"
+	// varForwardMaxIdleConnsPerHost is the keep-alive pool size of targets
+	varForwardMaxIdleConnsPerHost = "forward.max_idle_conns_per_host"
+
+	// Retries
+	varRetryMaxAttempts = "forward.retry.max_attempts"
+	varRetryMaxAge      = "forward.retry.max_age"
+	varRetryBackoff     = "forward.retry.backoff"
+	varRetryMaxBackoff  = "forward.retry.max_backoff"
+
+	// Circuit breakers
+	varBreakerFailures     = "forward.breaker.failures"
+	varBreakerErrorRate    = "forward.breaker.error_rate"
+	varBreakerWindow       = "forward.breaker.window"
+	varBreakerOpenDuration = "forward.breaker.open_duration"
+
+	// Target limits
+	varLimitMaxWait = "forward.limits.max_wait"
+
+	// Waking idled targets
+	varWakeTimeout  = "forward.wake.timeout"
+	varWakeInterval = "forward.wake.interval"
+
+	// Dead letters
+	varDeadLetterSize = "deadletter.size"
+
+	// Admin API
+	varAdminToken = "admin.token"
+
+	// Storage
+	varStorageBackend    = "storage.backend"
+	varForwardQueueLease = "forward.queue.lease"
+
+	// Embedded storage
+	varStorageBoltPath           = "storage.bolt.path"
+	varStorageRetention          = "storage.retention"
+	varStorageCompactionInterval = "storage.compaction.interval"
 )
 
+// RegistryRule maps an `image:tag` pattern to the Jenkins jobs
+// to trigger when a matching image is pushed
+type RegistryRule struct {
+	// Image is a path.Match pattern e.g. quay.io/org/*:latest
+	Image string `mapstructure:"image"`
+	// Jobs are job names with folders separated by `/`
+	Jobs []string `mapstructure:"jobs"`
+	// Repository is the git repository whose route triggers the jobs,
+	// the route of the image repository is used if empty
+	Repository string `mapstructure:"repository"`
+}
+
+// GitHubInstance describes a GitHub instance deliveries are accepted from
+type GitHubInstance struct {
+	// Host identifies the instance, as sent in X-GitHub-Enterprise-Host
+	Host string `mapstructure:"host"`
+	// APIURL defaults to https://<host>/api/v3
+	APIURL string `mapstructure:"api_url"`
+	// MetaURL defaults to <api_url>/meta
+	MetaURL string `mapstructure:"meta_url"`
+	// CAFile is an optional PEM bundle to verify the instance's certificate
+	CAFile string `mapstructure:"ca_file"`
+	// Secrets used to verify the signature of deliveries
+	Secrets []string `mapstructure:"secrets"`
+}
+
+// GenericSource maps the payload of a sender without dedicated
+// provider to an event. Fields starting with `$` are JSONPath
+// expressions evaluated against the payload, others are literals.
+type GenericSource struct {
+	Repository string `mapstructure:"repository"`
+	Ref        string `mapstructure:"ref"`
+	Commit     string `mapstructure:"commit"`
+	Event      string `mapstructure:"event"`
+	// Secret to verify the HMAC SHA256 signature in SignatureHeader
+	Secret          string `mapstructure:"secret"`
+	SignatureHeader string `mapstructure:"signature_header"`
+	// Token expected in TokenHeader
+	Token       string `mapstructure:"token"`
+	TokenHeader string `mapstructure:"token_header"`
+}
+
+// Tenant maps the repositories owned by a tenant
+type Tenant struct {
+	// Repositories are path.Match patterns on host/org/repo
+	// e.g. github.com/org/*
+	Repositories []string `mapstructure:"repositories"`
+	// Environment is OSIO, the default, or OSD for tenants whose
+	// Jenkins runs on the cluster assigned to them in osd.tenants
+	Environment string `mapstructure:"environment"`
+}
+
+// OSDCluster is an OpenShift Dedicated cluster hosting tenant Jenkins instances
+type OSDCluster struct {
+	// JenkinsURL of the tenants, `{tenant}` is replaced by the tenant name
+	JenkinsURL string `mapstructure:"jenkins_url"`
+	// Token is the bearer token to authenticate on the cluster
+	Token string `mapstructure:"token"`
+}
+
+// OSDTenant assigns an OpenShift Dedicated tenant to its cluster
+type OSDTenant struct {
+	Cluster string `mapstructure:"cluster"`
+	// Token overrides the token of the cluster
+	Token string `mapstructure:"token"`
+}
+
 // New creates a configuration reader object using a configurable configuration
 // file path.
 func New(configFilePath string) (*Config, error) {
@@ -119,6 +264,37 @@ func (c *Config) setConfigDefaults() {
 	c.v.SetDefault(varProxyURL, defaultProxyURL)
 	// Monitor IP Duration for duration between job to update IP
 	c.v.SetDefault(varMonitorIPDuration, defaultMonitorIPDuration)
+	// Duration between reloads of the routing table
+	c.v.SetDefault(varRoutingReloadDuration, defaultRoutingReloadDuration)
+
+	//-----------
+	// Forwarding
+	//-----------
+	c.v.SetDefault(varForwardAsync, defaultForwardAsync)
+	c.v.SetDefault(varForwardSyncTimeout, defaultForwardSyncTimeout)
+	c.v.SetDefault(varForwardQueueSize, defaultForwardQueueSize)
+	c.v.SetDefault(varForwardWorkers, defaultForwardWorkers)
+	c.v.SetDefault(varForwardMaxIdleConnsPerHost, defaultForwardMaxIdleConnsPerHost)
+	c.v.SetDefault(varRetryMaxAttempts, defaultRetryMaxAttempts)
+	c.v.SetDefault(varRetryMaxAge, defaultRetryMaxAge)
+	c.v.SetDefault(varRetryBackoff, defaultRetryBackoff)
+	c.v.SetDefault(varRetryMaxBackoff, defaultRetryMaxBackoff)
+	c.v.SetDefault(varBreakerFailures, defaultBreakerFailures)
+	c.v.SetDefault(varBreakerErrorRate, defaultBreakerErrorRate)
+	c.v.SetDefault(varBreakerWindow, defaultBreakerWindow)
+	c.v.SetDefault(varBreakerOpenDuration, defaultBreakerOpenDuration)
+	c.v.SetDefault(varLimitMaxWait, defaultLimitMaxWait)
+	c.v.SetDefault(varWakeTimeout, defaultWakeTimeout)
+	c.v.SetDefault(varWakeInterval, defaultWakeInterval)
+	c.v.SetDefault(varDeadLetterSize, defaultDeadLetterSize)
+	c.v.SetDefault(varForwardQueueLease, defaultForwardQueueLease)
+
+	//-----------------
+	// Embedded storage
+	//-----------------
+	c.v.SetDefault(varStorageBoltPath, defaultStorageBoltPath)
+	c.v.SetDefault(varStorageRetention, defaultStorageRetention)
+	c.v.SetDefault(varStorageCompactionInterval, defaultStorageCompactionInterval)
 }
 
 // DeveloperModeEnabled returns `true` if development related features (as set via default, config file, or environment variable),
@@ -177,6 +353,77 @@ func (c *Config) GetLogLevel() string {
 	return c.v.GetString(varLogLevel)
 }
 
+// GetPostgresHost returns the postgres host as set via default, config file, or environment variable
+func (c *Config) GetPostgresHost() string {
+	return c.v.GetString(varPostgresHost)
+}
+
+// GetPostgresPort returns the postgres port as set via default, config file, or environment variable
+func (c *Config) GetPostgresPort() int64 {
+	return c.v.GetInt64(varPostgresPort)
+}
+
+// GetPostgresUser returns the postgres user as set via default, config file, or environment variable
+func (c *Config) GetPostgresUser() string {
+	return c.v.GetString(varPostgresUser)
+}
+
+// GetPostgresDatabase returns the postgres database as set via default, config file, or environment variable
+func (c *Config) GetPostgresDatabase() string {
+	return c.v.GetString(varPostgresDatabase)
+}
+
+// GetPostgresPassword returns the postgres password as set via default, config file, or environment variable
+func (c *Config) GetPostgresPassword() string {
+	return c.v.GetString(varPostgresPassword)
+}
+
+// GetPostgresSSLMode returns the postgres sslmode as set via default, config file, or environment variable
+func (c *Config) GetPostgresSSLMode() string {
+	return c.v.GetString(varPostgresSSLMode)
+}
+
+// GetPostgresConnectionTimeout returns the postgres connection timeout in seconds
+func (c *Config) GetPostgresConnectionTimeout() int64 {
+	return c.v.GetInt64(varPostgresConnectionTimeout)
+}
+
+// GetPostgresTransactionTimeout returns the timeout of a postgres transaction
+func (c *Config) GetPostgresTransactionTimeout() time.Duration {
+	return time.Duration(c.v.GetInt64(varPostgresTransactionTimeout)) * time.Minute
+}
+
+// GetPostgresConnectionRetrySleep returns the duration to wait
+// before trying to connect to postgres again
+func (c *Config) GetPostgresConnectionRetrySleep() time.Duration {
+	return c.v.GetDuration(varPostgresConnectionRetrySleep)
+}
+
+// GetPostgresConnectionMaxIdle returns the maximum number of idle connections,
+// zero or less means no idle connections are kept
+func (c *Config) GetPostgresConnectionMaxIdle() int {
+	return c.v.GetInt(varPostgresConnectionMaxIdle)
+}
+
+// GetPostgresConnectionMaxOpen returns the maximum number of open connections,
+// zero or less means unlimited
+func (c *Config) GetPostgresConnectionMaxOpen() int {
+	return c.v.GetInt(varPostgresConnectionMaxOpen)
+}
+
+// GetPostgresConfigString returns a ready to use string for usage in sql.Open()
+func (c *Config) GetPostgresConfigString() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s connect_timeout=%d",
+		c.GetPostgresHost(),
+		c.GetPostgresPort(),
+		c.GetPostgresUser(),
+		c.GetPostgresPassword(),
+		c.GetPostgresDatabase(),
+		c.GetPostgresSSLMode(),
+		c.GetPostgresConnectionTimeout(),
+	)
+}
+
 // GetProxyURL returns URL to forward Webhook
 func (c *Config) GetProxyURL() string {
 	return c.v.GetString(varProxyURL)
@@ -187,3 +434,246 @@ func (c *Config) GetProxyURL() string {
 func (c *Config) GetMonitorIPDuration() time.Duration {
 	return c.v.GetDuration(varMonitorIPDuration)
 }
+
+// GetGiteaSecret returns the secret used to verify the signature
+// of Gitea and Gogs deliveries
+func (c *Config) GetGiteaSecret() string {
+	return c.v.GetString(varGiteaSecret)
+}
+
+// GetAzureUsername returns the basic-auth username expected
+// on Azure DevOps service hooks
+func (c *Config) GetAzureUsername() string {
+	return c.v.GetString(varAzureUsername)
+}
+
+// GetAzurePassword returns the basic-auth password expected
+// on Azure DevOps service hooks
+func (c *Config) GetAzurePassword() string {
+	return c.v.GetString(varAzurePassword)
+}
+
+// GetRegistryToken returns the token expected on
+// container registry notifications
+func (c *Config) GetRegistryToken() string {
+	return c.v.GetString(varRegistryToken)
+}
+
+// GetRegistrySecret returns the secret used to verify the
+// signature of container registry notifications
+func (c *Config) GetRegistrySecret() string {
+	return c.v.GetString(varRegistrySecret)
+}
+
+// GetRegistryRules returns the rules mapping pushed images
+// to the Jenkins jobs to trigger
+func (c *Config) GetRegistryRules() ([]RegistryRule, error) {
+	var rules []RegistryRule
+	if err := c.v.UnmarshalKey(varRegistryRules, &rules); err != nil {
+		return nil, errs.Wrap(err, "invalid "+varRegistryRules)
+	}
+	return rules, nil
+}
+
+// GetGitHubInstances returns the GitHub instances, besides
+// github.com, deliveries are accepted from
+func (c *Config) GetGitHubInstances() ([]GitHubInstance, error) {
+	var instances []GitHubInstance
+	if err := c.v.UnmarshalKey(varGitHubInstances, &instances); err != nil {
+		return nil, errs.Wrap(err, "invalid "+varGitHubInstances)
+	}
+	return instances, nil
+}
+
+// GetGenericSources returns the generic webhook sources by name.
+// Names are lower cased as all configuration keys.
+func (c *Config) GetGenericSources() (map[string]GenericSource, error) {
+	var sources map[string]GenericSource
+	if err := c.v.UnmarshalKey(varGenericSources, &sources); err != nil {
+		return nil, errs.Wrap(err, "invalid "+varGenericSources)
+	}
+	return sources, nil
+}
+
+// GetRoutingFile returns the path of the routing table file,
+// if empty every delivery is forwarded to the proxy URL
+func (c *Config) GetRoutingFile() string {
+	return c.v.GetString(varRoutingFile)
+}
+
+// IsRoutingStorage returns if the routing table is read from the
+// storage backend, managed with the admin API. The routing file or
+// the proxy URL is used until a table is stored.
+func (c *Config) IsRoutingStorage() bool {
+	return c.v.GetBool(varRoutingStorage)
+}
+
+// GetRoutingReloadDuration returns the duration between
+// reloads of the routing table
+func (c *Config) GetRoutingReloadDuration() time.Duration {
+	return c.v.GetDuration(varRoutingReloadDuration)
+}
+
+// GetTenants returns the tenants owning repositories by name
+func (c *Config) GetTenants() (map[string]Tenant, error) {
+	var tenants map[string]Tenant
+	if err := c.v.UnmarshalKey(varTenants, &tenants); err != nil {
+		return nil, errs.Wrap(err, "invalid "+varTenants)
+	}
+	return tenants, nil
+}
+
+// GetOSDClusters returns the OpenShift Dedicated clusters by name
+func (c *Config) GetOSDClusters() (map[string]OSDCluster, error) {
+	var clusters map[string]OSDCluster
+	if err := c.v.UnmarshalKey(varOSDClusters, &clusters); err != nil {
+		return nil, errs.Wrap(err, "invalid "+varOSDClusters)
+	}
+	return clusters, nil
+}
+
+// GetOSDTenants returns the OpenShift Dedicated tenants by name
+func (c *Config) GetOSDTenants() (map[string]OSDTenant, error) {
+	var tenants map[string]OSDTenant
+	if err := c.v.UnmarshalKey(varOSDTenants, &tenants); err != nil {
+		return nil, errs.Wrap(err, "invalid "+varOSDTenants)
+	}
+	return tenants, nil
+}
+
+// IsForwardAsync returns if deliveries are queued and acknowledged
+// with 202 Accepted instead of being forwarded synchronously
+func (c *Config) IsForwardAsync() bool {
+	return c.v.GetBool(varForwardAsync)
+}
+
+// GetForwardSyncTimeout returns how long a delivery is forwarded in
+// synchronous mode, retries and wake-ups included, 0 for no limit
+func (c *Config) GetForwardSyncTimeout() time.Duration {
+	return c.v.GetDuration(varForwardSyncTimeout)
+}
+
+// GetForwardQueueSize returns the number of deliveries which
+// can wait for a worker in asynchronous mode
+func (c *Config) GetForwardQueueSize() int {
+	return c.v.GetInt(varForwardQueueSize)
+}
+
+// GetForwardWorkers returns the number of workers forwarding
+// queued deliveries in asynchronous mode
+func (c *Config) GetForwardWorkers() int {
+	return c.v.GetInt(varForwardWorkers)
+}
+
+// GetForwardMaxIdleConnsPerHost returns the number of connections
+// kept alive to each target host between forwards
+func (c *Config) GetForwardMaxIdleConnsPerHost() int {
+	return c.v.GetInt(varForwardMaxIdleConnsPerHost)
+}
+
+// GetRetryMaxAttempts returns the default number of attempts to
+// forward a delivery to a target, routes may override it
+func (c *Config) GetRetryMaxAttempts() int {
+	return c.v.GetInt(varRetryMaxAttempts)
+}
+
+// GetRetryMaxAge returns the default duration after which a delivery
+// is no longer retried, routes may override it
+func (c *Config) GetRetryMaxAge() time.Duration {
+	return c.v.GetDuration(varRetryMaxAge)
+}
+
+// GetRetryBackoff returns the delay before the first retry,
+// doubled for every further retry
+func (c *Config) GetRetryBackoff() time.Duration {
+	return c.v.GetDuration(varRetryBackoff)
+}
+
+// GetRetryMaxBackoff returns the maximum delay between retries
+func (c *Config) GetRetryMaxBackoff() time.Duration {
+	return c.v.GetDuration(varRetryMaxBackoff)
+}
+
+// GetBreakerFailures returns the number of consecutive failures opening
+// the circuit breaker of a target host, zero disables it
+func (c *Config) GetBreakerFailures() int {
+	return c.v.GetInt(varBreakerFailures)
+}
+
+// GetBreakerErrorRate returns the share of failures of the last attempts
+// opening the circuit breaker of a target host, zero disables it
+func (c *Config) GetBreakerErrorRate() float64 {
+	return c.v.GetFloat64(varBreakerErrorRate)
+}
+
+// GetBreakerWindow returns the number of last attempts the error rate
+// of a target host is computed on
+func (c *Config) GetBreakerWindow() int {
+	return c.v.GetInt(varBreakerWindow)
+}
+
+// GetBreakerOpenDuration returns how long an open circuit breaker
+// fails fast before letting a probe through
+func (c *Config) GetBreakerOpenDuration() time.Duration {
+	return c.v.GetDuration(varBreakerOpenDuration)
+}
+
+// GetLimitMaxWait returns how long a forward waits for the limits
+// of its target when the route does not define it
+func (c *Config) GetLimitMaxWait() time.Duration {
+	return c.v.GetDuration(varLimitMaxWait)
+}
+
+// GetWakeTimeout returns how long a forward waits for an idled
+// target to be ready when the route does not define it
+func (c *Config) GetWakeTimeout() time.Duration {
+	return c.v.GetDuration(varWakeTimeout)
+}
+
+// GetWakeInterval returns how often the readiness of a woken target is
+// polled when the route does not define it
+func (c *Config) GetWakeInterval() time.Duration {
+	return c.v.GetDuration(varWakeInterval)
+}
+
+// GetDeadLetterSize returns the number of dead letters kept,
+// the oldest one is dropped when full
+func (c *Config) GetDeadLetterSize() int {
+	return c.v.GetInt(varDeadLetterSize)
+}
+
+// GetAdminToken returns the bearer token required by the admin API
+// e.g. dead letters, if empty the admin API rejects every request
+func (c *Config) GetAdminToken() string {
+	return c.v.GetString(varAdminToken)
+}
+
+// GetStorageBackend returns where deliveries are recorded and queued,
+// "postgres", "bolt" for an embedded file or empty to keep them in
+// memory without recording
+func (c *Config) GetStorageBackend() string {
+	return c.v.GetString(varStorageBackend)
+}
+
+// GetForwardQueueLease returns how long a worker may take to forward a
+// delivery of a durable queue before another worker claims it again
+func (c *Config) GetForwardQueueLease() time.Duration {
+	return c.v.GetDuration(varForwardQueueLease)
+}
+
+// GetStorageBoltPath returns the file of the embedded storage
+func (c *Config) GetStorageBoltPath() string {
+	return c.v.GetString(varStorageBoltPath)
+}
+
+// GetStorageRetention returns how long the embedded storage keeps
+// forwarded deliveries
+func (c *Config) GetStorageRetention() time.Duration {
+	return c.v.GetDuration(varStorageRetention)
+}
+
+// GetStorageCompactionInterval returns the duration between prunes
+// and compactions of the embedded storage
+func (c *Config) GetStorageCompactionInterval() time.Duration {
+	return c.v.GetDuration(varStorageCompactionInterval)
+}
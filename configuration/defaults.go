@@ -20,4 +20,26 @@ const (
 	defaultPostgresConnectionMaxOpen    = -1
 	defaultProxyURL                     = "http://localhost:9091"
 	defaultMonitorIPDuration            = 15 * time.Minute
+	defaultRoutingReloadDuration        = time.Minute
+	defaultForwardAsync                 = false
+	defaultForwardSyncTimeout           = 9 * time.Second
+	defaultForwardQueueSize             = 1000
+	defaultForwardWorkers               = 10
+	defaultForwardMaxIdleConnsPerHost   = 16
+	defaultRetryMaxAttempts             = 5
+	defaultRetryMaxAge                  = 10 * time.Minute
+	defaultRetryBackoff                 = time.Second
+	defaultRetryMaxBackoff              = time.Minute
+	defaultBreakerFailures              = 5
+	defaultBreakerErrorRate             = 0.5
+	defaultBreakerWindow                = 20
+	defaultBreakerOpenDuration          = 30 * time.Second
+	defaultLimitMaxWait                 = 30 * time.Second
+	defaultWakeTimeout                  = 5 * time.Minute
+	defaultWakeInterval                 = 5 * time.Second
+	defaultDeadLetterSize               = 1000
+	defaultForwardQueueLease            = 15 * time.Minute
+	defaultStorageBoltPath              = "fabric8-webhook.db"
+	defaultStorageRetention             = 7 * 24 * time.Hour
+	defaultStorageCompactionInterval    = time.Hour
 )
@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-webhook/app"
+	"github.com/fabric8-services/fabric8-webhook/deadletter"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/goadesign/goa"
+)
+
+// DeadletterController implements the deadletter resource.
+type DeadletterController struct {
+	*goa.Controller
+	letters   deadletter.Store
+	forwarder forward.Service
+	resolver  forward.Resolver
+	// secretHeaders are redacted from the shown deliveries
+	secretHeaders []string
+	adminToken    string
+}
+
+// NewDeadletterController creates a deadletter controller, every
+// action requires the admin token as bearer token. Dead letters are
+// replayed to their targets as resolved by r.
+func NewDeadletterController(service *goa.Service,
+	letters deadletter.Store,
+	fs forward.Service,
+	r forward.Resolver,
+	secretHeaders []string,
+	adminToken string) *DeadletterController {
+	return &DeadletterController{
+		Controller:    service.NewController("DeadletterController"),
+		letters:       letters,
+		forwarder:     fs,
+		resolver:      r,
+		secretHeaders: secretHeaders,
+		adminToken:    adminToken,
+	}
+}
+
+// List runs the list action.
+func (c *DeadletterController) List(ctx *app.ListDeadletterContext) error {
+	if !authorized(ctx.Request, c.adminToken) {
+		return ctx.Unauthorized()
+	}
+	letters, err := c.letters.List(stringValue(ctx.Target))
+	if err != nil {
+		return err
+	}
+	res := app.DeadLetterCollection{}
+	for _, l := range letters {
+		res = append(res, convertLetter(l, c.secretHeaders))
+	}
+	return ctx.OK(res)
+}
+
+// Show runs the show action.
+func (c *DeadletterController) Show(ctx *app.ShowDeadletterContext) error {
+	if !authorized(ctx.Request, c.adminToken) {
+		return ctx.Unauthorized()
+	}
+	l, err := c.letters.Get(ctx.ID)
+	if err == deadletter.ErrNotFound {
+		return ctx.NotFound()
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.OK(convertLetter(l, c.secretHeaders))
+}
+
+// Replay runs the replay action.
+func (c *DeadletterController) Replay(ctx *app.ReplayDeadletterContext) error {
+	if !authorized(ctx.Request, c.adminToken) {
+		return ctx.Unauthorized()
+	}
+	l, err := c.letters.Get(ctx.ID)
+	if err == deadletter.ErrNotFound {
+		return ctx.NotFound()
+	}
+	if err != nil {
+		return err
+	}
+	res, err := c.replay(l)
+	if err == deadletter.ErrNotFound {
+		return ctx.NotFound()
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.OK(res)
+}
+
+// ReplayAll runs the replay_all action.
+func (c *DeadletterController) ReplayAll(ctx *app.ReplayAllDeadletterContext) error {
+	if !authorized(ctx.Request, c.adminToken) {
+		return ctx.Unauthorized()
+	}
+	letters, err := c.letters.List(stringValue(ctx.Target))
+	if err != nil {
+		return err
+	}
+	res := app.ReplayCollection{}
+	for _, l := range letters {
+		r, err := c.replay(l)
+		if err == deadletter.ErrNotFound {
+			// replayed or discarded meanwhile
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		res = append(res, r)
+	}
+	return ctx.OK(res)
+}
+
+// Discard runs the discard action.
+func (c *DeadletterController) Discard(ctx *app.DiscardDeadletterContext) error {
+	if !authorized(ctx.Request, c.adminToken) {
+		return ctx.Unauthorized()
+	}
+	err := c.letters.Remove(ctx.ID)
+	if err == deadletter.ErrNotFound {
+		return ctx.NotFound()
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.NoContent()
+}
+
+func (c *DeadletterController) replay(l *deadletter.Letter) (*app.Replay, error) {
+	o, err := deadletter.Replay(c.letters, c.forwarder, c.resolver, l)
+	if err != nil {
+		return nil, err
+	}
+	res := &app.Replay{ID: l.ID, Target: l.Target.URL, Succeeded: o.Succeeded()}
+	if o.Err != nil {
+		msg := o.Err.Error()
+		res.Error = &msg
+	} else {
+		res.Status = &o.Response.StatusCode
+	}
+	c.Service.LogInfo("Replayed dead letter", "id", l.ID,
+		"delivery", l.Delivery.ID, "target", l.Target.URL,
+		"succeeded", res.Succeeded)
+	return res, nil
+}
+
+// convertLetter converts the dead letter, redacting the
+// credentials of the sender from the headers
+func convertLetter(l *deadletter.Letter, secretHeaders []string) *app.DeadLetter {
+	res := &app.DeadLetter{
+		ID:         l.ID,
+		DeliveryID: l.Delivery.ID,
+		Target:     l.Target.URL,
+		Method:     l.Delivery.Method,
+		Path:       l.Delivery.URL.RequestURI(),
+		Headers:    forward.Redact(l.Delivery.Header, secretHeaders...),
+		Body:       string(l.Delivery.Body),
+		Reason:     l.Reason,
+		CreatedAt:  l.Created,
+	}
+	for _, a := range l.Attempts {
+		attempt := &app.DeadLetterAttempt{Time: a.Time}
+		if a.Err != "" {
+			msg := a.Err
+			attempt.Error = &msg
+		} else {
+			status := a.StatusCode
+			attempt.Status = &status
+		}
+		res.Attempts = append(res.Attempts, attempt)
+	}
+	return res
+}
+
+// authorized checks the request carries the admin token, an
+// empty admin token rejects every request
+func authorized(req *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare(
+		[]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
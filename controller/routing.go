@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"io/ioutil"
+
+	commonerrors "github.com/fabric8-services/fabric8-common/errors"
+	"github.com/fabric8-services/fabric8-webhook/app"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+	"github.com/goadesign/goa"
+)
+
+// RoutingController implements the routing resource.
+type RoutingController struct {
+	*goa.Controller
+	routing routing.Service
+	// store keeps the routing table, nil if
+	// the table is not read from storage
+	store      routing.TableStore
+	adminToken string
+}
+
+// NewRoutingController creates a routing controller, every
+// action requires the admin token as bearer token.
+func NewRoutingController(service *goa.Service,
+	routes routing.Service,
+	store routing.TableStore,
+	adminToken string) *RoutingController {
+	return &RoutingController{
+		Controller: service.NewController("RoutingController"),
+		routing:    routes,
+		store:      store,
+		adminToken: adminToken,
+	}
+}
+
+// Show runs the show action.
+func (c *RoutingController) Show(ctx *app.ShowRoutingContext) error {
+	if !authorized(ctx.Request, c.adminToken) {
+		return ctx.Unauthorized()
+	}
+	if c.store == nil {
+		return ctx.NotFound()
+	}
+	doc, err := c.store.RoutingTable()
+	if err == routing.ErrNoTable {
+		return ctx.NotFound()
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.OK(doc)
+}
+
+// Update runs the update action.
+func (c *RoutingController) Update(ctx *app.UpdateRoutingContext) error {
+	if !authorized(ctx.Request, c.adminToken) {
+		return ctx.Unauthorized()
+	}
+	if c.store == nil {
+		return ctx.NotFound()
+	}
+	doc, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return err
+	}
+	t, err := routing.ParseTable(doc)
+	if err == nil {
+		err = t.Validate()
+	}
+	if err != nil {
+		return commonerrors.NewBadParameterError("routing table", err.Error())
+	}
+	if err := c.store.SaveRoutingTable(doc); err != nil {
+		return err
+	}
+	if err := c.routing.Reload(); err != nil {
+		return err
+	}
+	return ctx.NoContent()
+}
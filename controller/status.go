@@ -2,17 +2,22 @@ package controller
 
 import (
 	"github.com/fabric8-services/fabric8-webhook/app"
+	"github.com/fabric8-services/fabric8-webhook/forward"
 	"github.com/goadesign/goa"
 )
 
 // StatusController implements the status resource.
 type StatusController struct {
 	*goa.Controller
+	forwarder forward.Service
 }
 
 // NewStatusController creates a status controller.
-func NewStatusController(service *goa.Service) *StatusController {
-	return &StatusController{Controller: service.NewController("StatusController")}
+func NewStatusController(service *goa.Service, fs forward.Service) *StatusController {
+	return &StatusController{
+		Controller: service.NewController("StatusController"),
+		forwarder:  fs,
+	}
 }
 
 // Show runs the show action.
@@ -27,5 +32,11 @@ func (c *StatusController) Show(ctx *app.ShowStatusContext) error {
 		Commit:    app.Commit,
 		StartTime: app.StartTime,
 	}
+	if breakers := c.forwarder.Breakers(); len(breakers) > 0 {
+		res.Breakers = map[string]string{}
+		for host, state := range breakers {
+			res.Breakers[host] = string(state)
+		}
+	}
 	return ctx.OK(res)
 }
@@ -1,96 +1,264 @@
 package controller
 
 import (
-	"encoding/json"
 	"errors"
 	"io/ioutil"
-	"net/http/httputil"
-	"net/url"
+	"net/http"
+	"strings"
 
+	commonerrors "github.com/fabric8-services/fabric8-common/errors"
 	"github.com/fabric8-services/fabric8-webhook/app"
-	"github.com/fabric8-services/fabric8-webhook/build"
-	"github.com/fabric8-services/fabric8-webhook/verification"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/osd"
+	"github.com/fabric8-services/fabric8-webhook/provider"
+	"github.com/fabric8-services/fabric8-webhook/registry"
+	"github.com/fabric8-services/fabric8-webhook/resolver"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+	"github.com/fabric8-services/fabric8-webhook/storage"
 	"github.com/goadesign/goa"
 )
 
-// WebhookControllerConfiguration the Configuration for the WebhookController
-type webhookControllerConfiguration interface {
-	GetProxyURL() string
-}
-
 // WebhookController implements the Webhook resource.
 type WebhookController struct {
 	*goa.Controller
-	config       webhookControllerConfiguration
-	verification verification.Service
-	build        build.Service
+	providers provider.Service
+	registry  registry.Service
+	resolver  resolver.Service
+	forwarder forward.Service
+	// store records deliveries, may be nil
+	store storage.Store
+	// adminToken authorizes previews
+	adminToken string
 }
 
 // NewWebhookController creates a Webhook controller.
 func NewWebhookController(service *goa.Service,
-	config webhookControllerConfiguration,
-	vs verification.Service,
-	bs build.Service) *WebhookController {
+	ps provider.Service,
+	rs registry.Service,
+	ts resolver.Service,
+	fs forward.Service,
+	store storage.Store,
+	adminToken string) *WebhookController {
 	return &WebhookController{
-		Controller:   service.NewController("WebhookController"),
-		config:       config,
-		verification: vs,
-		build:        bs,
+		Controller: service.NewController("WebhookController"),
+		providers:  ps,
+		registry:   rs,
+		resolver:   ts,
+		forwarder:  fs,
+		store:      store,
+		adminToken: adminToken,
 	}
 }
 
-//GHHookStruct a simplified structure to get info from
-//a webhook request
-type GHHookStruct struct {
-	Repository struct {
-		Name     string `json:"name"`
-		FullName string `json:"full_name"`
-		GitURL   string `json:"git_url"`
-		CloneURL string `json:"clone_url"`
-	} `json:"repository"`
-}
-
 // Forward runs the forward action.
 func (c *WebhookController) Forward(ctx *app.ForwardWebhookContext) error {
+	p := c.providers.Detect(ctx.Request)
+	return c.forward(p, ctx.ResponseData, ctx.Request)
+}
+
+// Generic runs the generic action.
+func (c *WebhookController) Generic(ctx *app.GenericWebhookContext) error {
+	p, err := c.providers.Generic(ctx.Source)
+	if err == provider.ErrUnknownSource {
+		return ctx.NotFound()
+	}
+	if err != nil {
+		return err
+	}
+	return c.forward(p, ctx.ResponseData, ctx.Request)
+}
+
+// Preview runs the preview action.
+func (c *WebhookController) Preview(ctx *app.PreviewWebhookContext) error {
+	if !authorized(ctx.Request, c.adminToken) {
+		return ctx.Unauthorized()
+	}
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return err
+	}
+	// the sample is rendered as if posted to the forward action
+	ctx.Request.Header.Del("Authorization")
+	ctx.Request.URL.Path = strings.TrimSuffix(ctx.Request.URL.Path, "/preview")
+	d := forward.NewDelivery(ctx.Request, body)
+
+	event, err := c.providers.Detect(ctx.Request).Parse(ctx.Request, body)
+	if err != nil {
+		return err
+	}
+	targets, _, err := c.targets(event)
+	if err != nil {
+		return err
+	}
+	res := app.PreviewCollection{}
+	for _, p := range c.forwarder.Preview(d, targets) {
+		res = append(res, convertPreview(p))
+	}
+	return ctx.OK(res)
+}
+
+func convertPreview(p *forward.Preview) *app.Preview {
+	res := &app.Preview{Target: p.Target}
+	if p.Err != nil {
+		e := p.Err.Error()
+		res.Error = &e
+		return res
+	}
+	body := string(p.Body)
+	res.Method = &p.Method
+	res.URL = &p.URL
+	res.Headers = p.Header
+	res.Body = &body
+	return res
+}
+
+// forward verifies and parses the request with the provider
+// and forwards it according to the repository's environment
+func (c *WebhookController) forward(p provider.Provider,
+	rw http.ResponseWriter, req *http.Request) error {
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
 
-	isVerify, err := c.verification.Verify(ctx.Request)
+	d := forward.NewDelivery(req, body)
+	isVerify, err := p.Verify(req, body)
 	if err != nil {
 		c.Service.LogInfo("Error while verifying", "err:", err)
 		return err
 	}
+	record := &storage.Record{
+		Delivery: d,
+		Provider: p.Name(),
+		Verified: isVerify,
+		Status:   storage.StatusReceived,
+	}
 	if !isVerify {
+		record.Status = storage.StatusRejected
+		c.save(record)
 		return errors.New("Request from unauthorized source")
 	}
+	c.save(record)
+
+	event, err := p.Parse(req, body)
+	if err != nil {
+		c.unrouted(record, err)
+		return err
+	}
+	targets, policy, err := c.targets(event)
+	if err != nil {
+		c.unrouted(record, err)
+		return err
+	}
+
+	record.Targets = targets
+	record.Policy = policy
+	c.save(record)
+	res := c.forwarder.Dispatch(d, targets, policy)
+	for _, o := range res.Outcomes {
+		if o.Err != nil {
+			c.Service.LogError("Forwarding failed", "delivery", d.ID,
+				"target", o.Target, "repository", event.GitURL,
+				"attempts", o.Attempts, "err", o.Err)
+			continue
+		}
+		c.Service.LogInfo("Forwarded", "delivery", d.ID, "target", o.Target,
+			"repository", event.GitURL, "attempts", o.Attempts,
+			"status", o.Response.StatusCode)
+	}
+	if res.CoalescedInto != "" {
+		c.Service.LogInfo("Coalesced", "delivery", d.ID,
+			"repository", event.GitURL, "into", res.CoalescedInto)
+	}
+	// queued deliveries are recorded by the queue once forwarded
+	if len(res.Outcomes) > 0 || res.CoalescedInto != "" {
+		record.Status = storage.StatusOf(res)
+		record.Attempts = d.Attempts()
+		c.save(record)
+	}
+	return res.Response.Write(rw)
+}
+
+// targets resolves the targets of the event according to the
+// environment of its repository, and the response policy
+func (c *WebhookController) targets(event *provider.Event) ([]forward.Target, forward.Policy, error) {
+	targets, policy, err := c.resolver.Targets(forward.Event{
+		Type:        event.Type,
+		GitURL:      event.GitURL,
+		Ref:         event.Ref,
+		Commit:      event.Commit,
+		PullRequest: event.PullRequest,
+	})
+	switch err {
+	case routing.ErrNoRoute:
+		return nil, "", commonerrors.NewNotFoundError("route", event.GitURL)
+	case osd.ErrTenantNotConfigured:
+		return nil, "", commonerrors.NewNotFoundError("OSD tenant of repository", event.GitURL)
+	}
+	return targets, policy, err
+}
+
+// save records the delivery if a store is configured,
+// failing to record it does not fail the delivery
+func (c *WebhookController) save(r *storage.Record) {
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Save(r); err != nil {
+		c.Service.LogError("Failed to record delivery",
+			"delivery", r.Delivery.ID, "err", err)
+	}
+}
+
+// unrouted records the delivery as not routed because of err
+func (c *WebhookController) unrouted(r *storage.Record, err error) {
+	r.Status = storage.StatusUnrouted
+	r.Error = err.Error()
+	c.save(r)
+}
+
+// Registry runs the registry action.
+func (c *WebhookController) Registry(ctx *app.RegistryWebhookContext) error {
 
 	body, err := ioutil.ReadAll(ctx.Request.Body)
 	if err != nil {
 		return err
 	}
 
-	gh := GHHookStruct{}
-	err = json.Unmarshal(body, &gh)
+	if !c.registry.Verify(ctx.Request, body) {
+		return errors.New("Request from unauthorized source")
+	}
+
+	images, err := c.registry.Parse(body)
 	if err != nil {
 		return err
 	}
 
-	envType, err := c.build.GetEnvironmentType(gh.Repository.GitURL)
+	targets, err := c.registry.Targets(images)
 	if err != nil {
+		c.Service.LogError("Error while resolving jobs", "err", err)
 		return err
 	}
-	switch envType {
-	case "OSIO":
-		u, err := url.Parse(c.config.GetProxyURL())
-		if err != nil {
-			return errors.New("Invalid Proxy URL:" +
-				c.config.GetProxyURL())
+	res := c.forwarder.Forward(forward.NewDelivery(ctx.Request, body), targets, forward.PolicyAll)
+	// jobs of routes with several targets are triggered on each
+	var jobs, failed []string
+	triggered := map[string]bool{}
+	for i, o := range res.Outcomes {
+		job := targets[i].Trigger.Job
+		if !o.Succeeded() {
+			c.Service.LogError("Error while triggering job", "job", job,
+				"target", o.Target, "attempts", o.Attempts, "err", o.Err)
+			failed = append(failed, job)
+			continue
 		}
-		proxy := httputil.NewSingleHostReverseProxy(u)
-		proxy.ServeHTTP(ctx.ResponseData, ctx.Request)
-	case "OSD":
-	//TODO
-	default:
-		return errors.New("Invalid Environment Type")
-
+		if !triggered[job] {
+			triggered[job] = true
+			jobs = append(jobs, job)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New("Failed to trigger jobs: " + strings.Join(failed, ", "))
 	}
-	return nil
+	return ctx.OK([]byte(strings.Join(jobs, "\n")))
 }
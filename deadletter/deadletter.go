@@ -0,0 +1,153 @@
+package deadletter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fabric8-services/fabric8-webhook/forward"
+)
+
+// ErrNotFound is returned for unknown dead letters
+var ErrNotFound = errors.New("Dead letter not found")
+
+// Letter is a delivery which permanently failed for a target
+type Letter struct {
+	ID       string
+	Delivery *forward.Delivery
+	// Target has no secrets, it is resolved again when replayed
+	Target forward.Target
+	// Reason is why the last attempt failed
+	Reason   string
+	Attempts []forward.Attempt
+	Created  time.Time
+}
+
+// Store keeps dead letters until they are replayed or discarded
+type Store interface {
+	forward.DeadLetters
+	// List returns the dead letters, oldest first, of
+	// the target or of every target if empty
+	List(target string) ([]*Letter, error)
+	Get(id string) (*Letter, error)
+	Remove(id string) error
+}
+
+// NewLetter returns the dead letter of the delivery for the target,
+// the target is kept without its secrets
+func NewLetter(d *forward.Delivery, t forward.Target, o *forward.Outcome) *Letter {
+	l := &Letter{
+		ID:       forward.NewID(),
+		Delivery: d.Copy(),
+		Target:   t.Redacted(),
+		Created:  time.Now(),
+	}
+	if o.Err != nil {
+		l.Reason = o.Err.Error()
+	} else {
+		l.Reason = fmt.Sprintf("Target responded with %d", o.Response.StatusCode)
+	}
+	for _, a := range d.Attempts() {
+		if a.Target == t.URL {
+			l.Attempts = append(l.Attempts, a)
+		}
+	}
+	return l
+}
+
+// memoryStore keeps the dead letters in memory, dropping
+// the oldest one when full
+type memoryStore struct {
+	lock    sync.RWMutex
+	letters map[string]*Letter
+	size    int
+}
+
+// NewMemoryStore returns a Store keeping up to size dead letters in memory
+func NewMemoryStore(size int) Store {
+	return &memoryStore{letters: map[string]*Letter{}, size: size}
+}
+
+func (s *memoryStore) Add(d *forward.Delivery, t forward.Target, o *forward.Outcome) error {
+	l := NewLetter(d, t, o)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.size > 0 && len(s.letters) >= s.size {
+		var oldest *Letter
+		for _, l := range s.letters {
+			if oldest == nil || l.Created.Before(oldest.Created) {
+				oldest = l
+			}
+		}
+		delete(s.letters, oldest.ID)
+	}
+	s.letters[l.ID] = l
+	return nil
+}
+
+func (s *memoryStore) List(target string) ([]*Letter, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	letters := []*Letter{}
+	for _, l := range s.letters {
+		if target == "" || l.Target.URL == target {
+			letters = append(letters, l)
+		}
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].Created.Before(letters[j].Created)
+	})
+	return letters, nil
+}
+
+func (s *memoryStore) Get(id string) (*Letter, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	l, ok := s.letters[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return l, nil
+}
+
+func (s *memoryStore) Remove(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.letters[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.letters, id)
+	return nil
+}
+
+// Replay removes the dead letter and dispatches its delivery again to
+// the target as currently routed, so it is queued, debounced and
+// ordered like live deliveries. fs adds a new dead letter if it fails
+// again. The dead letter is kept if the target can not be resolved,
+// and added again if the delivery can not be queued, the outcome then
+// holds the error. Queued replays respond with 202 Accepted.
+func Replay(s Store, fs forward.Service, r forward.Resolver, l *Letter) (*forward.Outcome, error) {
+	t, err := r.Resolve(l.Target)
+	if err != nil {
+		return &forward.Outcome{Target: l.Target.URL, Err: err}, nil
+	}
+	if err := s.Remove(l.ID); err != nil {
+		return nil, err
+	}
+	res := fs.Dispatch(l.Delivery.Copy(), []forward.Target{t}, forward.PolicyPrimary)
+	if len(res.Outcomes) > 0 {
+		return res.Outcomes[0], nil
+	}
+	// queued, or coalesced into a later delivery
+	o := &forward.Outcome{Target: t.URL, Response: res.Response}
+	if o.Succeeded() {
+		return o, nil
+	}
+	o = &forward.Outcome{Target: t.URL, Err: errors.New(string(res.Response.Body))}
+	if err := s.Add(l.Delivery, l.Target, o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
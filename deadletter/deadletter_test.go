@@ -0,0 +1,154 @@
+package deadletter
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-webhook/forward"
+)
+
+func newDelivery() *forward.Delivery {
+	req := httptest.NewRequest("POST", "/api/webhook", nil)
+	req.Header.Set("X-GitHub-Event", "push")
+	return forward.NewDelivery(req, []byte(`{"ref":"refs/heads/master"}`))
+}
+
+func Test_memoryStore(t *testing.T) {
+	s := NewMemoryStore(2)
+	failed := &forward.Outcome{Target: "http://a", Err: errors.New("connection refused")}
+	for _, target := range []string{"http://a", "http://b", "http://a"} {
+		if err := s.Add(newDelivery(), forward.Target{URL: target}, failed); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	all, _ := s.List("")
+	if len(all) != 2 {
+		t.Fatalf("List() = %d letters, want 2 as the oldest is dropped", len(all))
+	}
+	if all[0].Target.URL != "http://b" || all[0].Reason != "connection refused" {
+		t.Errorf("List()[0] = %v, %v", all[0].Target.URL, all[0].Reason)
+	}
+	a, _ := s.List("http://a")
+	if len(a) != 1 {
+		t.Errorf("List(http://a) = %d letters, want 1", len(a))
+	}
+
+	if _, err := s.Get(all[0].ID); err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+	if err := s.Remove(all[0].ID); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if _, err := s.Get(all[0].ID); err != ErrNotFound {
+		t.Errorf("Get() after Remove() error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Remove(all[0].ID); err != ErrNotFound {
+		t.Errorf("Remove() twice error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+// forwarder forwards deliveries with status, or
+// queues them with 202 Accepted if queued is set
+type forwarder struct {
+	forward.Service
+	status int
+	queued bool
+	target forward.Target
+}
+
+func (f *forwarder) Dispatch(d *forward.Delivery, targets []forward.Target, policy forward.Policy) *forward.Result {
+	f.target = targets[0]
+	res := &forward.Response{StatusCode: f.status, Header: http.Header{}, Body: []byte("Queue is full")}
+	if f.queued {
+		return &forward.Result{Response: res}
+	}
+	o := &forward.Outcome{Target: targets[0].URL, Response: res}
+	return &forward.Result{Outcomes: []*forward.Outcome{o}, Response: o.Response}
+}
+
+func TestReplay(t *testing.T) {
+	s := NewMemoryStore(0)
+	s.Add(newDelivery(), forward.Target{URL: "http://a", Token: "old"},
+		&forward.Outcome{Target: "http://a", Response: &forward.Response{StatusCode: 503}})
+	letters, _ := s.List("")
+	l := letters[0]
+	if l.Reason != "Target responded with 503" {
+		t.Errorf("Reason = %v", l.Reason)
+	}
+	if l.Target.Token != "" {
+		t.Errorf("Target.Token = %v, want the target kept without secrets", l.Target.Token)
+	}
+
+	removed := errors.New("Target is no longer routed")
+	o, err := Replay(s, &forwarder{status: 200}, resolver{err: removed}, l)
+	if err != nil || o.Err != removed {
+		t.Errorf("Replay() of unresolved target = %v, %v, want %v", o, err, removed)
+	}
+	if _, err := s.Get(l.ID); err != nil {
+		t.Errorf("letter of unresolved target removed, error = %v", err)
+	}
+
+	fs := &forwarder{status: 200}
+	o, err = Replay(s, fs, resolver{token: "current"}, l)
+	if err != nil || !o.Succeeded() {
+		t.Errorf("Replay() = %v, %v", o, err)
+	}
+	if fs.target.Token != "current" {
+		t.Errorf("Replay() forwarded to %+v, want the current target", fs.target)
+	}
+	if _, err := s.Get(l.ID); err != ErrNotFound {
+		t.Errorf("replayed letter still stored, error = %v", err)
+	}
+	if _, err := Replay(s, &forwarder{status: 200}, resolver{}, l); err != ErrNotFound {
+		t.Errorf("Replay() twice error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestReplay_Queued(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		wantSuccess bool
+		wantLetters int
+	}{
+		{name: "Queued", status: http.StatusAccepted, wantSuccess: true},
+		{name: "Queue Full", status: http.StatusServiceUnavailable, wantLetters: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore(0)
+			s.Add(newDelivery(), forward.Target{URL: "http://a"},
+				&forward.Outcome{Target: "http://a", Response: &forward.Response{StatusCode: 503}})
+			letters, _ := s.List("")
+			o, err := Replay(s, &forwarder{status: tt.status, queued: true}, resolver{}, letters[0])
+			if err != nil || o.Succeeded() != tt.wantSuccess {
+				t.Errorf("Replay() = %+v, %v, want succeeded %v", o, err, tt.wantSuccess)
+			}
+			if letters, _ = s.List(""); len(letters) != tt.wantLetters {
+				t.Errorf("Replay() left %d dead letters, want %d", len(letters), tt.wantLetters)
+			}
+			if tt.wantLetters > 0 && letters[0].Reason != "Queue is full" {
+				t.Errorf("Reason = %v, want the queue error", letters[0].Reason)
+			}
+		})
+	}
+}
+
+// resolver resolves targets with the token, or fails with err
+type resolver struct {
+	token string
+	err   error
+}
+
+func (r resolver) Resolve(t forward.Target) (forward.Target, error) {
+	if r.err != nil {
+		return t, r.err
+	}
+	t.Token = r.token
+	return t, nil
+}
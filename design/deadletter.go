@@ -0,0 +1,134 @@
+package design
+
+import (
+	d "github.com/goadesign/goa/design"
+	a "github.com/goadesign/goa/design/apidsl"
+)
+
+// DeadLetterAttempt is one attempt to forward a dead letter
+var DeadLetterAttempt = a.Type("DeadLetterAttempt", func() {
+	a.Attribute("time", d.DateTime, "When the attempt was made")
+	a.Attribute("status", d.Integer, "HTTP status of the target's response")
+	a.Attribute("error", d.String, "Error if the target could not be reached")
+	a.Required("time")
+})
+
+// DeadLetter defines a delivery which permanently failed for a target
+var DeadLetter = a.MediaType("application/vnd.deadletter+json", func() {
+	a.Description("A delivery which permanently failed for a target")
+	a.Attributes(func() {
+		a.Attribute("id", d.String, "ID of the dead letter")
+		a.Attribute("delivery_id", d.String, "ID of the delivery")
+		a.Attribute("target", d.String, "URL of the target")
+		a.Attribute("method", d.String, "Method of the original request")
+		a.Attribute("path", d.String, "Path and query of the original request")
+		a.Attribute("headers", a.HashOf(d.String, a.ArrayOf(d.String)), "Headers of the original request")
+		a.Attribute("body", d.String, "Body of the original request")
+		a.Attribute("reason", d.String, "Why the last attempt failed")
+		a.Attribute("attempts", a.ArrayOf(DeadLetterAttempt), "Attempts made to forward the delivery")
+		a.Attribute("created_at", d.DateTime, "When the delivery was dead-lettered")
+		a.Required("id", "delivery_id", "target", "method", "path", "body", "reason", "created_at")
+	})
+	a.View("default", func() {
+		a.Attribute("id")
+		a.Attribute("delivery_id")
+		a.Attribute("target")
+		a.Attribute("method")
+		a.Attribute("path")
+		a.Attribute("headers")
+		a.Attribute("body")
+		a.Attribute("reason")
+		a.Attribute("attempts")
+		a.Attribute("created_at")
+	})
+})
+
+// Replay defines the outcome of replaying a dead letter
+var Replay = a.MediaType("application/vnd.replay+json", func() {
+	a.Description("The outcome of replaying a dead letter, a failed" +
+		" replay is dead-lettered again")
+	a.Attributes(func() {
+		a.Attribute("id", d.String, "ID of the replayed dead letter")
+		a.Attribute("target", d.String, "URL of the target")
+		a.Attribute("succeeded", d.Boolean, "Whether the target accepted the delivery")
+		a.Attribute("status", d.Integer, "HTTP status of the target's response, 202 if queued")
+		a.Attribute("error", d.String, "Error if the target could not be reached")
+		a.Required("id", "target", "succeeded")
+	})
+	a.View("default", func() {
+		a.Attribute("id")
+		a.Attribute("target")
+		a.Attribute("succeeded")
+		a.Attribute("status")
+		a.Attribute("error")
+	})
+})
+
+var _ = a.Resource("deadletter", func() {
+
+	a.BasePath("/deadletters")
+
+	a.Action("list", func() {
+		a.Routing(
+			a.GET(""),
+		)
+		a.Description("List the dead letters")
+		a.Params(func() {
+			a.Param("target", d.String, "Only list the dead letters of this target")
+		})
+		a.Response(d.OK, a.CollectionOf(DeadLetter))
+		a.Response(d.Unauthorized)
+	})
+
+	a.Action("show", func() {
+		a.Routing(
+			a.GET("/:id"),
+		)
+		a.Description("Show a dead letter")
+		a.Params(func() {
+			a.Param("id", d.String, "ID of the dead letter")
+		})
+		a.Response(d.OK, DeadLetter)
+		a.Response(d.Unauthorized)
+		a.Response(d.NotFound)
+	})
+
+	a.Action("replay", func() {
+		a.Routing(
+			a.POST("/:id/replay"),
+		)
+		a.Description("Forward a dead letter to its target again")
+		a.Params(func() {
+			a.Param("id", d.String, "ID of the dead letter")
+		})
+		a.Response(d.OK, Replay)
+		a.Response(d.Unauthorized)
+		a.Response(d.NotFound)
+	})
+
+	a.Action("replay_all", func() {
+		a.Routing(
+			a.POST("/replay"),
+		)
+		a.Description("Forward every dead letter to its target again")
+		a.Params(func() {
+			a.Param("target", d.String, "Only replay the dead letters of this target")
+		})
+		a.Response(d.OK, a.CollectionOf(Replay))
+		a.Response(d.Unauthorized)
+	})
+
+	a.Action("discard", func() {
+		a.Routing(
+			a.DELETE("/:id"),
+		)
+		a.Description("Discard a dead letter")
+		a.Params(func() {
+			a.Param("id", d.String, "ID of the dead letter")
+		})
+		a.Response(d.NoContent)
+		a.Response(d.Unauthorized)
+		a.Response(d.NotFound)
+	})
+
+})
@@ -0,0 +1,35 @@
+package design
+
+import (
+	d "github.com/goadesign/goa/design"
+	a "github.com/goadesign/goa/design/apidsl"
+)
+
+var _ = a.Resource("routing", func() {
+
+	a.BasePath("/routing")
+
+	a.Action("show", func() {
+		a.Routing(
+			a.GET(""),
+		)
+		a.Description("Show the routing table stored in the storage backend," +
+			" in the YAML or JSON format of the routing file")
+		a.Response(d.OK)
+		a.Response(d.Unauthorized)
+		a.Response(d.NotFound)
+	})
+
+	a.Action("update", func() {
+		a.Routing(
+			a.PUT(""),
+		)
+		a.Description("Replace the routing table stored in the storage backend" +
+			" with the request body, in the YAML or JSON format of the routing" +
+			" file. The table is validated and applied at once.")
+		a.Response(d.NoContent)
+		a.Response(d.Unauthorized)
+		a.Response(d.NotFound)
+	})
+
+})
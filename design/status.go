@@ -13,6 +13,8 @@ var Status = a.MediaType("application/vnd.status+json", func() {
 		a.Attribute("buildTime", d.String, "The time when built")
 		a.Attribute("startTime", d.String, "The time when started")
 		a.Attribute("error", d.String, "The error if any")
+		a.Attribute("breakers", a.HashOf(d.String, d.String),
+			"The state of the circuit breaker of every target host: closed, open or half-open")
 		a.Required("commit", "buildTime", "startTime")
 	})
 	a.View("default", func() {
@@ -20,6 +22,7 @@ var Status = a.MediaType("application/vnd.status+json", func() {
 		a.Attribute("buildTime")
 		a.Attribute("startTime")
 		a.Attribute("error")
+		a.Attribute("breakers")
 	})
 })
 
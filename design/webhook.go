@@ -5,6 +5,29 @@ import (
 	a "github.com/goadesign/goa/design/apidsl"
 )
 
+// Preview defines the request a delivery would be sent to a target with
+var Preview = a.MediaType("application/vnd.preview+json", func() {
+	a.Description("The request a delivery would be sent to a target with," +
+		" without credentials and with secrets redacted")
+	a.Attributes(func() {
+		a.Attribute("target", d.String, "URL of the target")
+		a.Attribute("method", d.String, "Method of the request")
+		a.Attribute("url", d.String, "URL of the request")
+		a.Attribute("headers", a.HashOf(d.String, a.ArrayOf(d.String)), "Headers of the request")
+		a.Attribute("body", d.String, "Body of the request")
+		a.Attribute("error", d.String, "Error rendering the request")
+		a.Required("target")
+	})
+	a.View("default", func() {
+		a.Attribute("target")
+		a.Attribute("method")
+		a.Attribute("url")
+		a.Attribute("headers")
+		a.Attribute("body")
+		a.Attribute("error")
+	})
+})
+
 var _ = a.Resource("Webhook", func() {
 
 	a.BasePath("/webhook")
@@ -19,4 +42,40 @@ var _ = a.Resource("Webhook", func() {
 		a.Response(d.Unauthorized)
 	})
 
+	a.Action("generic", func() {
+		a.Routing(
+			a.POST("/generic/:source"),
+		)
+		a.Params(func() {
+			a.Param("source", d.String, "Name of the configured generic source")
+		})
+		a.Description("Get a webhook request of a generic source, map it" +
+			" with the source's JSONPath expressions and forward it after verification")
+		a.Response(d.OK)
+		a.Response(d.Unauthorized)
+		a.Response(d.NotFound)
+	})
+
+	a.Action("preview", func() {
+		a.Routing(
+			a.POST("/preview"),
+		)
+		a.Description("Render the requests a sample webhook request would be" +
+			" forwarded with to the targets of its route, without sending them." +
+			" Requires the admin token as bearer token.")
+		a.Response(d.OK, a.CollectionOf(Preview))
+		a.Response(d.Unauthorized)
+		a.Response(d.NotFound)
+	})
+
+	a.Action("registry", func() {
+		a.Routing(
+			a.POST("/registry"),
+		)
+		a.Description("Get a Docker Registry or Quay push notification" +
+			" and trigger the Jenkins jobs matching the pushed images")
+		a.Response(d.OK)
+		a.Response(d.Unauthorized)
+	})
+
 })
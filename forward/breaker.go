@@ -0,0 +1,218 @@
+package forward
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the error of attempts failing fast
+// because the circuit breaker of the target is open
+var ErrCircuitOpen = errors.New("Circuit breaker of the target is open")
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed lets every attempt through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails every attempt fast
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets one probe through to decide
+	// whether to close or to open again
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerSettings define when circuit breakers open
+type BreakerSettings struct {
+	// Failures is the number of consecutive failures opening
+	// the breaker, zero disables it
+	Failures int
+	// ErrorRate opens the breaker when the share of failures of the
+	// last Window attempts reaches it, zero disables it
+	ErrorRate float64
+	Window    int
+	// OpenDuration is how long the breaker fails fast before probing
+	OpenDuration time.Duration
+}
+
+// enabled tells whether breakers open on consecutive
+// failures or on the error rate
+func (s BreakerSettings) enabled() bool {
+	return s.Failures > 0 || (s.ErrorRate > 0 && s.Window > 0)
+}
+
+// breaker is the circuit breaker of a target host
+type breaker struct {
+	lock     sync.Mutex
+	settings BreakerSettings
+	host     string
+	state    BreakerState
+	openedAt time.Time
+	// probing is set while the probe of the half-open breaker is in flight
+	probing     bool
+	consecutive int
+	// outcomes of the last attempts, true for failures
+	outcomes []bool
+	next     int
+}
+
+func newBreaker(host string, settings BreakerSettings) *breaker {
+	b := &breaker{settings: settings, host: host}
+	b.setState(BreakerClosed)
+	return b
+}
+
+// Allow tells whether an attempt may go through
+func (b *breaker) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.settings.OpenDuration {
+			breakerRejections.WithLabelValues(b.host).Inc()
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			breakerRejections.WithLabelValues(b.host).Inc()
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Record accounts the outcome of an allowed attempt
+func (b *breaker) Record(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		if failed {
+			b.open()
+		} else {
+			b.reset()
+		}
+		return
+	}
+	if !failed {
+		b.consecutive = 0
+	} else {
+		b.consecutive++
+	}
+	if b.settings.Window > 0 {
+		if len(b.outcomes) < b.settings.Window {
+			b.outcomes = append(b.outcomes, failed)
+		} else {
+			b.outcomes[b.next] = failed
+			b.next = (b.next + 1) % b.settings.Window
+		}
+	}
+	if b.state == BreakerClosed && (b.exhausted() || b.tripped()) {
+		b.open()
+	}
+}
+
+// exhausted tells whether the consecutive failures reach the limit
+func (b *breaker) exhausted() bool {
+	return b.settings.Failures > 0 && b.consecutive >= b.settings.Failures
+}
+
+// tripped tells whether the error rate of a full window reaches the limit
+func (b *breaker) tripped() bool {
+	if b.settings.ErrorRate <= 0 || b.settings.Window <= 0 || len(b.outcomes) < b.settings.Window {
+		return false
+	}
+	failures := 0
+	for _, f := range b.outcomes {
+		if f {
+			failures++
+		}
+	}
+	return float64(failures)/float64(len(b.outcomes)) >= b.settings.ErrorRate
+}
+
+func (b *breaker) open() {
+	b.openedAt = time.Now()
+	b.setState(BreakerOpen)
+}
+
+func (b *breaker) reset() {
+	b.consecutive = 0
+	b.outcomes = nil
+	b.next = 0
+	b.setState(BreakerClosed)
+}
+
+func (b *breaker) setState(state BreakerState) {
+	b.state = state
+	for _, s := range []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		breakerState.WithLabelValues(b.host, string(s)).Set(v)
+	}
+}
+
+// State returns the state of the breaker, an open breaker
+// due for probing is reported half-open
+func (b *breaker) State() BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.settings.OpenDuration {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// failed tells whether the outcome counts as failure for the breaker,
+// responses below 500 prove the target is up
+func failed(o *Outcome) bool {
+	return o.Err != nil || o.Response.StatusCode >= 500
+}
+
+// breakers holds the circuit breaker of every target host
+type breakers struct {
+	lock     sync.Mutex
+	settings BreakerSettings
+	hosts    map[string]*breaker
+}
+
+// get returns the breaker of the target, nil if breakers are disabled
+func (bs *breakers) get(target string) *breaker {
+	if bs == nil || !bs.settings.enabled() {
+		return nil
+	}
+	host := target
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	b, ok := bs.hosts[host]
+	if !ok {
+		b = newBreaker(host, bs.settings)
+		bs.hosts[host] = b
+	}
+	return b
+}
+
+func (bs *breakers) states() map[string]BreakerState {
+	states := map[string]BreakerState{}
+	if bs == nil {
+		return states
+	}
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	for host, b := range bs.hosts {
+		states[host] = b.State()
+	}
+	return states
+}
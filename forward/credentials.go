@@ -0,0 +1,152 @@
+package forward
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Credentials authenticate the forwards to a target e.g. a Jenkins
+// requiring authentication. At most one of APIToken, Token and File is set.
+type Credentials struct {
+	// User authenticates with basic authentication and
+	// the API token in APIToken or File
+	User     string `mapstructure:"user"`
+	APIToken string `mapstructure:"api_token"`
+	// Token is sent as bearer token unless User is set
+	Token string `mapstructure:"token"`
+	// File is a mounted secret holding the API token of User,
+	// or the bearer token. It is read on every forward so
+	// rotated secrets are picked up.
+	File string `mapstructure:"file"`
+	// Crumb adds the Jenkins CSRF crumb to the forwards
+	Crumb bool `mapstructure:"crumb"`
+	// CrumbIssuer is the URL of the crumb issuer, the
+	// /crumbIssuer/api/json of the target's host if empty
+	CrumbIssuer string `mapstructure:"crumb_issuer"`
+}
+
+// Validate checks a single secret is set and the crumb issuer is an URL
+func (c Credentials) Validate() error {
+	n := 0
+	for _, s := range []string{c.APIToken, c.Token, c.File} {
+		if s != "" {
+			n++
+		}
+	}
+	if n > 1 {
+		return errors.New("at most one of api_token, token and file must be set")
+	}
+	if c.User != "" && c.Token != "" {
+		return errors.New("token is a bearer token, use api_token with user")
+	}
+	if c.User == "" && c.APIToken != "" {
+		return errors.New("api_token requires user")
+	}
+	if c.CrumbIssuer != "" {
+		if u, err := url.Parse(c.CrumbIssuer); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid crumb issuer %q", c.CrumbIssuer)
+		}
+	}
+	return nil
+}
+
+// authorize sets the Authorization header of req
+func (c Credentials) authorize(req *http.Request) error {
+	secret := c.APIToken
+	if c.Token != "" {
+		secret = c.Token
+	}
+	if c.File != "" {
+		b, err := ioutil.ReadFile(c.File)
+		if err != nil {
+			return err
+		}
+		secret = strings.TrimSpace(string(b))
+	}
+	switch {
+	case c.User != "":
+		req.SetBasicAuth(c.User, secret)
+	case secret != "":
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	return nil
+}
+
+// crumbIssuer returns the URL of the crumb issuer of the target
+func (c Credentials) crumbIssuer(target *url.URL) string {
+	if c.CrumbIssuer != "" {
+		return c.CrumbIssuer
+	}
+	return (&url.URL{Scheme: target.Scheme, Host: target.Host, Path: "/crumbIssuer/api/json"}).String()
+}
+
+// crumb is a Jenkins CSRF crumb with the session it was issued to
+type crumb struct {
+	Field   string `json:"crumbRequestField"`
+	Value   string `json:"crumb"`
+	cookies []*http.Cookie
+}
+
+// crumbs caches the crumb of every crumb issuer and user
+type crumbs struct {
+	lock   sync.Mutex
+	issued map[string]*crumb
+}
+
+// add sets the crumb of the target on req, fetching it
+// from the issuer if not cached or if refresh is set
+func (cs *crumbs) add(req *http.Request, e *endpoint, c Credentials, refresh bool) error {
+	issuer := c.crumbIssuer(e.url)
+	key := c.User + "@" + issuer
+	cs.lock.Lock()
+	cr, ok := cs.issued[key]
+	cs.lock.Unlock()
+	if !ok || refresh {
+		var err error
+		if cr, err = fetchCrumb(e.client, issuer, c); err != nil {
+			return err
+		}
+		cs.lock.Lock()
+		cs.issued[key] = cr
+		cs.lock.Unlock()
+	}
+	req.Header.Set(cr.Field, cr.Value)
+	for _, cookie := range cr.cookies {
+		req.AddCookie(cookie)
+	}
+	return nil
+}
+
+func fetchCrumb(client *http.Client, issuer string, c Credentials) (*crumb, error) {
+	req, err := http.NewRequest("GET", issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("crumb issuer responded with %d", res.StatusCode)
+	}
+	cr := &crumb{}
+	if err := json.NewDecoder(res.Body).Decode(cr); err != nil {
+		return nil, err
+	}
+	if cr.Field == "" || cr.Value == "" {
+		return nil, errors.New("crumb issuer responded without crumb")
+	}
+	// crumbs are bound to the session of the request issuing them
+	cr.cookies = res.Cookies()
+	return cr, nil
+}
@@ -0,0 +1,119 @@
+package forward
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// debounced is a delivery held until the debounce window of its
+// repository and ref closes
+type debounced struct {
+	delivery *Delivery
+	// superseded completes the delivery once a later one replaces it
+	superseded func(by *Delivery)
+	// release forwards the delivery once the window closes, nil
+	// if the delivery is queued until then
+	release func()
+}
+
+// window is the debounce window of a repository and ref
+type window struct {
+	closes time.Time
+	latest *debounced
+}
+
+// debouncer coalesces the pushes to the same repository and ref
+// received within a window, only the latest one is forwarded
+type debouncer struct {
+	lock    sync.Mutex
+	pending map[string]*window
+}
+
+// add holds the delivery until the window of key closes, the pending
+// delivery of key is superseded. The window opens with the first
+// delivery, so steady pushes do not delay the forwards indefinitely.
+// queue, if not nil, is called with the close of the window before
+// the pending delivery is superseded, the delivery is not added if
+// it fails.
+func (db *debouncer) add(key string, d time.Duration, p *debounced, queue func(closes time.Time) error) error {
+	db.lock.Lock()
+	w, ok := db.pending[key]
+	if ok && !time.Now().Before(w.closes) {
+		// closed but not released yet, its delivery may be forwarding
+		ok = false
+	}
+	if !ok {
+		w = &window{closes: time.Now().Add(d)}
+	}
+	// queued while locked, a window closing meanwhile would
+	// release the delivery before it is queued
+	if queue != nil {
+		if err := queue(w.closes); err != nil {
+			db.lock.Unlock()
+			return err
+		}
+	}
+	prev := w.latest
+	w.latest = p
+	if !ok {
+		db.pending[key] = w
+		time.AfterFunc(d, func() {
+			db.lock.Lock()
+			if db.pending[key] == w {
+				delete(db.pending, key)
+			}
+			latest := w.latest
+			db.lock.Unlock()
+			if latest.release != nil {
+				latest.release()
+			}
+		})
+	}
+	db.lock.Unlock()
+	if prev != nil {
+		coalesced.Inc()
+		prev.superseded(p.delivery)
+	}
+	return nil
+}
+
+// debounceKey returns the key of the pushes coalesced with the
+// delivery to the targets, empty if the delivery is not debounced
+func debounceKey(targets []Target) (string, time.Duration) {
+	if len(targets) == 0 || targets[0].Debounce <= 0 {
+		return "", 0
+	}
+	e := targets[0].Event
+	// only pushes are superseded by later ones,
+	// pull requests are built per change
+	if e.Type != "push" || e.Ref == "" || e.PullRequest != 0 {
+		return "", 0
+	}
+	key := []string{e.GitURL, e.Ref}
+	for _, t := range targets {
+		key = append(key, t.URL)
+	}
+	return strings.Join(key, " "), targets[0].Debounce
+}
+
+// coalescedResult is the result of a delivery superseded by a later one
+func coalescedResult(d, by *Delivery) *Result {
+	body, _ := json.Marshal(map[string]string{
+		"delivery_id":    d.ID,
+		"coalesced_into": by.ID,
+	})
+	h := http.Header{}
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Delivery-Id", d.ID)
+	return &Result{
+		CoalescedInto: by.ID,
+		Response: &Response{
+			StatusCode: http.StatusAccepted,
+			Header:     h,
+			Body:       body,
+		},
+	}
+}
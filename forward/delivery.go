@@ -0,0 +1,196 @@
+package forward
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery is a verified inbound request to forward
+type Delivery struct {
+	// ID identifies the delivery
+	ID     string
+	Method string
+	URL    *url.URL
+	Header http.Header
+	Body   []byte
+	// RemoteAddr is the address the delivery came from
+	RemoteAddr string
+
+	// attempts made to forward the delivery to its targets
+	lock     sync.Mutex
+	attempts []Attempt
+}
+
+// NewDelivery captures the inbound request, whose
+// body has already been read, for forwarding
+func NewDelivery(req *http.Request, body []byte) *Delivery {
+	return &Delivery{
+		ID:         NewID(),
+		Method:     req.Method,
+		URL:        req.URL,
+		Header:     copyHeader(req.Header),
+		Body:       body,
+		RemoteAddr: req.RemoteAddr,
+	}
+}
+
+// Copy returns the delivery without its attempts, to forward it again
+func (d *Delivery) Copy() *Delivery {
+	return &Delivery{
+		ID:         d.ID,
+		Method:     d.Method,
+		URL:        d.URL,
+		Header:     copyHeader(d.Header),
+		Body:       d.Body,
+		RemoteAddr: d.RemoteAddr,
+	}
+}
+
+// Attempts returns the attempts made to forward the delivery
+func (d *Delivery) Attempts() []Attempt {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]Attempt(nil), d.attempts...)
+}
+
+// record adds the outcome of an attempt to the delivery
+func (d *Delivery) record(o *Outcome) {
+	a := Attempt{Target: o.Target, Time: time.Now()}
+	if o.Err != nil {
+		a.Err = o.Err.Error()
+	} else {
+		a.StatusCode = o.Response.StatusCode
+		a.Location = o.Response.Header.Get("Location")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.attempts = append(d.attempts, a)
+}
+
+// build builds the outbound request to the target according to its type
+func (d *Delivery) build(target *url.URL, t Target) (*http.Request, error) {
+	switch {
+	case t.Type == TypeTekton:
+		return d.tekton(target, t)
+	case t.Type == TypeBuildConfig:
+		return d.buildConfig(target, t)
+	case t.Trigger.enabled():
+		return d.trigger(target, t)
+	}
+	return d.request(target, t)
+}
+
+// request builds the outbound request to the target the same way
+// httputil.NewSingleHostReverseProxy does: the path of the delivery
+// is appended to the target's path and the queries are merged.
+// The header policy of t applies to the headers.
+func (d *Delivery) request(target *url.URL, t Target) (*http.Request, error) {
+	u := *target
+	u.Path = singleJoiningSlash(target.Path, d.URL.Path)
+	if target.RawQuery == "" || d.URL.RawQuery == "" {
+		u.RawQuery = target.RawQuery + d.URL.RawQuery
+	} else {
+		u.RawQuery = target.RawQuery + "&" + d.URL.RawQuery
+	}
+	return d.passThrough(u.String(), t)
+}
+
+// passThrough builds the request sending the delivery as received
+// to rawurl, with the headers of the policy of t
+func (d *Delivery) passThrough(rawurl string, t Target) (*http.Request, error) {
+	body := d.Body
+	if t.Transform.enabled() {
+		var err error
+		if body, err = t.Transform.render(d.headerData(t)); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(d.Method, rawurl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = copyHeader(d.Header)
+	if t.Transform.enabled() {
+		req.Header.Set("Content-Type", t.Transform.contentType())
+	}
+	removeHopHeaders(req.Header)
+	t.Headers.filter(req.Header)
+	if clientIP, _, err := net.SplitHostPort(d.RemoteAddr); err == nil {
+		if prior, ok := req.Header["X-Forwarded-For"]; ok {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		req.Header.Set("X-Forwarded-For", clientIP)
+	}
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	if err := t.Headers.apply(req, d.headerData(t)); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// NewID returns a random UUID (version 4) identifying deliveries
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+func copyHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, vv := range h {
+		c[k] = append([]string(nil), vv...)
+	}
+	return c
+}
+
+// hopHeaders are removed when forwarding, see
+// http://www.w3.org/Protocols/rfc2616/rfc2616-sec13.html
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, f := range h["Connection"] {
+		for _, sf := range strings.Split(f, ",") {
+			if sf = strings.TrimSpace(sf); sf != "" {
+				h.Del(sf)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
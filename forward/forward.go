@@ -0,0 +1,738 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+)
+
+// Policy defines how the response to the sender
+// is derived from the outcomes of all targets
+type Policy string
+
+const (
+	// PolicyPrimary responds with the response of the first target
+	PolicyPrimary Policy = "primary"
+	// PolicyAll responds with the response of the first target if
+	// every target succeeded, with 502 Bad Gateway otherwise
+	PolicyAll Policy = "all"
+	// PolicyAccepted always responds with 202 Accepted
+	PolicyAccepted Policy = "accepted"
+)
+
+// Validate checks the policy is known, empty means PolicyPrimary
+func (p Policy) Validate() error {
+	switch p {
+	case "", PolicyPrimary, PolicyAll, PolicyAccepted:
+		return nil
+	}
+	return fmt.Errorf("unknown response policy %q", p)
+}
+
+// Target is a destination deliveries are forwarded to
+type Target struct {
+	URL string
+	// Type defines how deliveries are sent to the target
+	Type TargetType
+	// Token is a bearer token to authenticate on the target
+	Token string
+	// Retry caps the retries of failed forwards
+	Retry Retry
+	// Limits cap the concurrency and rate of forwards
+	Limits Limits
+	// Transport configures the connections to the target
+	Transport Transport
+	// Headers defines the headers of the forwarded requests
+	Headers HeaderPolicy
+	// Credentials authenticate the forwards to the target
+	Credentials Credentials
+	// Wake wakes the target when idled
+	Wake Wake
+	// Trigger calls the Jenkins REST API instead of forwarding
+	Trigger Trigger
+	// BuildConfig configures the webhook of BuildConfig targets
+	BuildConfig BuildConfig
+	// Transform renders the outbound body
+	Transform Transform
+	// Debounce is the window pushes to the same repository and
+	// ref are coalesced within, only the latest is forwarded
+	Debounce time.Duration
+	// Ordering defines which deliveries are forwarded serially
+	Ordering Ordering
+	// Tenant owns the repository of the delivery, may be empty
+	Tenant string
+	// Event is the change of the delivery
+	Event Event
+}
+
+// Redacted returns the target without its secrets, for storing. Stored
+// targets are resolved again before they are forwarded.
+func (t Target) Redacted() Target {
+	t.Token = ""
+	t.Credentials.APIToken = ""
+	t.Credentials.Token = ""
+	t.BuildConfig.Secret = ""
+	return t
+}
+
+// Outcome is the result of forwarding a delivery to a target
+type Outcome struct {
+	Target string
+	// Response is nil if Err is set
+	Response *Response
+	Err      error
+	// Attempts is the number of attempts made
+	Attempts int
+}
+
+// Succeeded tells whether the target accepted the delivery
+func (o *Outcome) Succeeded() bool {
+	return o.Err == nil && o.Response.StatusCode < 300
+}
+
+// Response is a buffered HTTP response
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Result holds the outcome of every target and the
+// response to send back according to the policy
+type Result struct {
+	Outcomes []*Outcome
+	Response *Response
+	// CoalescedInto is the ID of the later delivery forwarded
+	// instead of this one, which then has no outcome
+	CoalescedInto string
+}
+
+// Service defines forwarding of deliveries to targets
+type Service interface {
+	// Forward sends the delivery to every target independently
+	Forward(d *Delivery, targets []Target, policy Policy) *Result
+	// Dispatch forwards the delivery within the sync timeout, or queues
+	// it in asynchronous mode.
+	// Debounced pushes are held until their window closes, ordered
+	// deliveries wait for the previous ones of their repository.
+	Dispatch(d *Delivery, targets []Target, policy Policy) *Result
+	// Breakers returns the state of the circuit breaker of every target host
+	Breakers() map[string]BreakerState
+	// Prepare builds the clients of the targets ahead of the forwards,
+	// dropping those of the targets prepared before
+	Prepare(targets []Target) error
+	// Preview renders the requests of the delivery to the
+	// targets without sending them
+	Preview(d *Delivery, targets []Target) []*Preview
+}
+
+// DeadLetters keeps deliveries which permanently failed for a target
+type DeadLetters interface {
+	Add(d *Delivery, t Target, o *Outcome) error
+}
+
+// Resolver resolves the current configuration of the targets of
+// deliveries forwarded after they were routed, e.g. replayed
+type Resolver interface {
+	Resolve(t Target) (Target, error)
+}
+
+// serviceConfiguration the Configuration needed by the forward service
+type serviceConfiguration interface {
+	IsForwardAsync() bool
+	GetForwardSyncTimeout() time.Duration
+	GetForwardQueueSize() int
+	GetForwardQueueLease() time.Duration
+	GetForwardWorkers() int
+	GetRetryMaxAttempts() int
+	GetRetryMaxAge() time.Duration
+	GetRetryBackoff() time.Duration
+	GetRetryMaxBackoff() time.Duration
+	GetBreakerFailures() int
+	GetBreakerErrorRate() float64
+	GetBreakerWindow() int
+	GetBreakerOpenDuration() time.Duration
+	GetLimitMaxWait() time.Duration
+	GetForwardMaxIdleConnsPerHost() int
+	GetWakeTimeout() time.Duration
+	GetWakeInterval() time.Duration
+}
+
+type service struct {
+	pool *pool
+	// queue of deliveries, nil in synchronous mode
+	queue Queue
+	// syncTimeout caps forwarding in synchronous mode, 0 for no limit
+	syncTimeout time.Duration
+	// retry holds the default retry caps
+	retry      Retry
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// deadLetters may be nil
+	deadLetters DeadLetters
+	// resolver resolves the targets of queued deliveries, may be nil
+	resolver  Resolver
+	breakers  *breakers
+	limiters  *limiters
+	wakers    *wakers
+	debouncer *debouncer
+	sequencer *sequencer
+	// pop serializes popping jobs and queueing them in the
+	// sequencer, so ordered jobs keep the order of the queue
+	pop sync.Mutex
+	// parked are the popped jobs waiting for the previous ordered
+	// ones, their leases are renewed until they are forwarded
+	parkLock sync.Mutex
+	parked   map[string]*Job
+}
+
+// New returns a forward service instance, starting the workers in
+// asynchronous mode. Deliveries are queued in q, or in memory if nil.
+// Failed deliveries are added to dl, if not nil. The targets of queued
+// deliveries are resolved by r before they are forwarded, if not nil.
+func New(config serviceConfiguration, dl DeadLetters, q Queue, r Resolver) Service {
+	s := &service{
+		pool: newPool(config.GetForwardMaxIdleConnsPerHost()),
+		retry: Retry{
+			MaxAttempts: config.GetRetryMaxAttempts(),
+			MaxAge:      config.GetRetryMaxAge(),
+		},
+		syncTimeout: config.GetForwardSyncTimeout(),
+		backoff:     config.GetRetryBackoff(),
+		maxBackoff:  config.GetRetryMaxBackoff(),
+		deadLetters: dl,
+		resolver:    r,
+		breakers: &breakers{
+			settings: BreakerSettings{
+				Failures:     config.GetBreakerFailures(),
+				ErrorRate:    config.GetBreakerErrorRate(),
+				Window:       config.GetBreakerWindow(),
+				OpenDuration: config.GetBreakerOpenDuration(),
+			},
+			hosts: map[string]*breaker{},
+		},
+		limiters: &limiters{
+			maxWait: config.GetLimitMaxWait(),
+			targets: map[string]*limiter{},
+		},
+		wakers: &wakers{
+			timeout:  config.GetWakeTimeout(),
+			interval: config.GetWakeInterval(),
+			inFlight: map[string]*waking{},
+		},
+		debouncer: &debouncer{pending: map[string]*window{}},
+		sequencer: &sequencer{waiting: map[string][]func(){}},
+		parked:    map[string]*Job{},
+	}
+	if config.IsForwardAsync() {
+		if q == nil {
+			q = NewMemoryQueue(config.GetForwardQueueSize())
+		}
+		s.queue = q
+		queueCapacity.Set(float64(config.GetForwardQueueSize()))
+		workers.Set(float64(config.GetForwardWorkers()))
+		for i := 0; i < config.GetForwardWorkers(); i++ {
+			go s.work()
+		}
+		if lease := config.GetForwardQueueLease(); lease > 0 {
+			go s.renewEvery(lease / 2)
+		}
+	}
+	return s
+}
+
+// Dispatch forwards the delivery synchronously within the sync timeout,
+// or in asynchronous mode queues it and responds with 202 Accepted and
+// the delivery ID. Pushes superseded within their debounce window are
+// completed as coalesced.
+func (s *service) Dispatch(d *Delivery, targets []Target, policy Policy) *Result {
+	key, window := debounceKey(targets)
+	if window == 0 {
+		return s.dispatch(d, targets, policy)
+	}
+	if s.queue == nil {
+		// the sender waits for the window to close
+		ctx, cancel := s.syncContext()
+		defer cancel()
+		done := make(chan *Result, 1)
+		s.debouncer.add(key, window, &debounced{
+			delivery:   d,
+			superseded: func(by *Delivery) { done <- coalescedResult(d, by) },
+			release:    func() { done <- s.forwardOrdered(ctx, d, targets, policy) },
+		}, nil)
+		return <-done
+	}
+	// the delivery is queued right away, not popped before the
+	// window closes, so it is kept across restarts
+	j := &Job{Delivery: d, Targets: targets}
+	err := s.debouncer.add(key, window, &debounced{
+		delivery: d,
+		superseded: func(by *Delivery) {
+			s.done(j, coalescedResult(d, by))
+		},
+	}, func(closes time.Time) error {
+		j.NotBefore = closes
+		return s.enqueue(j)
+	})
+	if err != nil {
+		return unavailable(err)
+	}
+	return accepted(d)
+}
+
+// dispatch forwards or queues the delivery without debouncing
+func (s *service) dispatch(d *Delivery, targets []Target, policy Policy) *Result {
+	if s.queue == nil {
+		ctx, cancel := s.syncContext()
+		defer cancel()
+		return s.forwardOrdered(ctx, d, targets, policy)
+	}
+	if err := s.enqueue(&Job{Delivery: d, Targets: targets}); err != nil {
+		return unavailable(err)
+	}
+	return accepted(d)
+}
+
+// syncContext bounds forwarding in synchronous mode by the sync
+// timeout, the sender does not wait any longer for the response
+func (s *service) syncContext() (context.Context, context.CancelFunc) {
+	if s.syncTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), s.syncTimeout)
+}
+
+// unavailable is the response to deliveries which could not be queued
+func unavailable(err error) *Result {
+	res := &Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{},
+		Body:       []byte(err.Error()),
+	}
+	res.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	return &Result{Response: res}
+}
+
+// enqueue pushes the job to the queue
+func (s *service) enqueue(j *Job) error {
+	if err := s.queue.Push(j); err != nil {
+		if err != ErrQueueFull {
+			log.Error(nil, map[string]interface{}{
+				"delivery_id": j.Delivery.ID,
+				"err":         err,
+			}, "failed to queue delivery")
+		}
+		return err
+	}
+	s.updateQueueDepth()
+	return nil
+}
+
+// accepted is the response to queued deliveries
+func accepted(d *Delivery) *Result {
+	body, _ := json.Marshal(map[string]string{"delivery_id": d.ID})
+	h := http.Header{}
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Delivery-Id", d.ID)
+	return &Result{Response: &Response{
+		StatusCode: http.StatusAccepted,
+		Header:     h,
+		Body:       body,
+	}}
+}
+
+// done completes the job in the queue
+func (s *service) done(j *Job, res *Result) {
+	if err := s.queue.Done(j, res); err != nil {
+		log.Error(nil, map[string]interface{}{
+			"delivery_id": j.Delivery.ID,
+			"err":         err,
+		}, "failed to complete queued delivery")
+	}
+}
+
+// work forwards queued deliveries until the queue is closed. Ordered
+// deliveries waiting for the previous ones of their repository do not
+// hold the worker, the worker forwarding those forwards them next.
+func (s *service) work() {
+	for {
+		s.pop.Lock()
+		j, err := s.queue.Pop()
+		if err == ErrQueueClosed {
+			s.pop.Unlock()
+			return
+		}
+		if err != nil {
+			s.pop.Unlock()
+			log.Error(nil, map[string]interface{}{
+				"err": err,
+			}, "failed to get queued delivery")
+			time.Sleep(time.Second)
+			continue
+		}
+		s.updateQueueDepth()
+		key := orderKey(j.Targets)
+		now := key == ""
+		if !now {
+			s.park(j)
+			now = s.sequencer.add(key, func() {
+				s.unpark(j)
+				s.process(j)
+			})
+			if now {
+				s.unpark(j)
+			}
+		}
+		s.pop.Unlock()
+		if !now {
+			continue
+		}
+		s.process(j)
+		if key != "" {
+			for f := s.sequencer.next(key); f != nil; f = s.sequencer.next(key) {
+				f()
+			}
+		}
+	}
+}
+
+func (s *service) park(j *Job) {
+	s.parkLock.Lock()
+	defer s.parkLock.Unlock()
+	s.parked[j.Delivery.ID] = j
+}
+
+func (s *service) unpark(j *Job) {
+	s.parkLock.Lock()
+	defer s.parkLock.Unlock()
+	delete(s.parked, j.Delivery.ID)
+}
+
+// renewEvery renews the leases of the parked jobs, so no
+// other worker claims them while they wait
+func (s *service) renewEvery(d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.parkLock.Lock()
+		var jobs []*Job
+		for _, j := range s.parked {
+			jobs = append(jobs, j)
+		}
+		s.parkLock.Unlock()
+		for _, j := range jobs {
+			if err := s.queue.Renew(j); err != nil {
+				log.Error(nil, map[string]interface{}{
+					"delivery_id": j.Delivery.ID,
+					"err":         err,
+				}, "failed to renew the lease of queued delivery")
+			}
+		}
+	}
+}
+
+// process forwards the queued job and completes it
+func (s *service) process(j *Job) {
+	busyWorkers.Inc()
+	defer busyWorkers.Dec()
+	targets, failed := s.resolve(j)
+	res := s.forward(context.Background(), j.Delivery, targets, PolicyAccepted)
+	res.Outcomes = append(res.Outcomes, failed...)
+	for _, o := range res.Outcomes {
+		if o.Err != nil {
+			log.Error(nil, map[string]interface{}{
+				"delivery_id": j.Delivery.ID,
+				"target":      o.Target,
+				"attempts":    o.Attempts,
+				"err":         o.Err,
+			}, "forwarding failed")
+			continue
+		}
+		log.Info(nil, map[string]interface{}{
+			"delivery_id": j.Delivery.ID,
+			"target":      o.Target,
+			"attempts":    o.Attempts,
+			"status":      o.Response.StatusCode,
+		}, "forwarded")
+	}
+	s.done(j, res)
+}
+
+// resolve returns the targets of the queued job as currently routed,
+// and the outcomes of those which can not be resolved
+func (s *service) resolve(j *Job) ([]Target, []*Outcome) {
+	if s.resolver == nil {
+		return j.Targets, nil
+	}
+	var targets []Target
+	var failed []*Outcome
+	for _, t := range j.Targets {
+		resolved, err := s.resolver.Resolve(t)
+		if err != nil {
+			o := &Outcome{Target: t.URL, Err: err}
+			recordOutcome(o)
+			s.deadLetter(j.Delivery, t, o)
+			failed = append(failed, o)
+			continue
+		}
+		targets = append(targets, resolved)
+	}
+	return targets, failed
+}
+
+// forwardOrdered forwards the delivery once the previous ordered
+// deliveries of its repository were forwarded, retries included
+func (s *service) forwardOrdered(ctx context.Context, d *Delivery, targets []Target, policy Policy) *Result {
+	key := orderKey(targets)
+	if key == "" {
+		return s.forward(ctx, d, targets, policy)
+	}
+	var res *Result
+	s.sequencer.run(key, func() { res = s.forward(ctx, d, targets, policy) })
+	return res
+}
+
+func (s *service) updateQueueDepth() {
+	if n, err := s.queue.Len(); err == nil {
+		queueDepth.Set(float64(n))
+	}
+}
+
+func (s *service) Forward(d *Delivery, targets []Target, policy Policy) *Result {
+	return s.forward(context.Background(), d, targets, policy)
+}
+
+// forward sends the delivery to every target until ctx is done
+func (s *service) forward(ctx context.Context, d *Delivery, targets []Target, policy Policy) *Result {
+	res := &Result{Outcomes: make([]*Outcome, len(targets))}
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+			res.Outcomes[i] = s.send(ctx, d, t)
+			recordOutcome(res.Outcomes[i])
+			if !res.Outcomes[i].Succeeded() {
+				s.deadLetter(d, t, res.Outcomes[i])
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	res.Response = respond(res.Outcomes, policy)
+	return res
+}
+
+// deadLetter adds the failed delivery to the dead letters, if any
+func (s *service) deadLetter(d *Delivery, t Target, o *Outcome) {
+	if s.deadLetters == nil {
+		return
+	}
+	if err := s.deadLetters.Add(d, t, o); err != nil {
+		log.Error(nil, map[string]interface{}{
+			"delivery_id": d.ID,
+			"target":      t.URL,
+			"err":         err,
+		}, "failed to add dead letter")
+	}
+}
+
+func (s *service) Prepare(targets []Target) error {
+	return s.pool.prepare(targets)
+}
+
+func (s *service) Breakers() map[string]BreakerState {
+	return s.breakers.states()
+}
+
+// send forwards the delivery to the target, retrying connection
+// errors and retryable statuses within the target's retry caps.
+// Attempts wait for the limits of the target, those not getting
+// through before the deadline are retried later. Attempts fail fast
+// while the breaker of the target is open. No attempt is made, nor
+// waited for, past the deadline of ctx.
+func (s *service) send(ctx context.Context, d *Delivery, t Target) *Outcome {
+	retry := t.Retry.or(s.retry)
+	b := s.breakers.get(t.URL)
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		release, err := s.limiters.acquire(ctx, t)
+		var o *Outcome
+		switch {
+		case err != nil:
+			o = &Outcome{Target: t.URL, Err: err}
+		case b != nil && !b.Allow():
+			release()
+			o = &Outcome{Target: t.URL, Err: ErrCircuitOpen, Attempts: attempt}
+			d.record(o)
+			return o
+		default:
+			o = s.attempt(ctx, d, t)
+			release()
+			if b != nil {
+				b.Record(failed(o))
+			}
+		}
+		o.Attempts = attempt
+		d.record(o)
+		if !retryable(o) || attempt >= retry.MaxAttempts {
+			return o
+		}
+		wait := s.delay(o, attempt)
+		if time.Since(start)+wait > retry.MaxAge || !fits(ctx, wait) {
+			return o
+		}
+		retries.Inc()
+		time.Sleep(wait)
+	}
+}
+
+// fits tells whether waiting d ends before the deadline of ctx, if any
+func fits(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Now().Add(d).Before(deadline)
+}
+
+// attempt makes one attempt to forward the delivery to the target
+func (s *service) attempt(ctx context.Context, d *Delivery, t Target) *Outcome {
+	o := &Outcome{Target: t.URL}
+	e, err := s.pool.get(t)
+	if err != nil {
+		o.Err = err
+		return o
+	}
+	if t.Wake.enabled() {
+		if err := s.wakeIdled(ctx, e, d, t); err != nil {
+			o.Err = err
+			return o
+		}
+	}
+	res, err := s.do(ctx, d, e, t, false)
+	if err == nil && res.StatusCode == http.StatusForbidden && t.Credentials.Crumb {
+		// the crumb expired with its session
+		res.Body.Close()
+		res, err = s.do(ctx, d, e, t, true)
+	}
+	if err == nil && res.StatusCode == http.StatusServiceUnavailable && t.Wake.enabled() {
+		// the target was idled since checked, or has no idler status API
+		res.Body.Close()
+		if err = s.wakers.wake(ctx, e, t, d.headerData(t)); err == nil {
+			res, err = s.do(ctx, d, e, t, false)
+		}
+	}
+	if err != nil {
+		o.Err = err
+		return o
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		o.Err = err
+		return o
+	}
+	removeHopHeaders(res.Header)
+	o.Response = &Response{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
+	}
+	return o
+}
+
+// wakeIdled wakes the target if the idler status API reports it idled
+func (s *service) wakeIdled(ctx context.Context, e *endpoint, d *Delivery, t Target) error {
+	data := d.headerData(t)
+	idled, err := s.wakers.idled(ctx, e.client, t, data)
+	if err != nil {
+		// the target is woken on 503 anyway
+		log.Warn(nil, map[string]interface{}{
+			"target": t.URL,
+			"err":    err,
+		}, "failed to get the idler status of the target")
+		return nil
+	}
+	if !idled {
+		return nil
+	}
+	return s.wakers.wake(ctx, e, t, data)
+}
+
+// do sends the delivery to the endpoint of the target with its
+// credentials, refreshing the crumb if refreshCrumb is set
+func (s *service) do(ctx context.Context, d *Delivery, e *endpoint, t Target, refreshCrumb bool) (*http.Response, error) {
+	req, err := d.build(e.url, t)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := t.Credentials.authorize(req); err != nil {
+		return nil, err
+	}
+	if t.Credentials.Crumb {
+		if err := s.pool.crumbs.add(req, e, t.Credentials, refreshCrumb); err != nil {
+			return nil, err
+		}
+	}
+	res, err := e.client.Do(req)
+	if ue, ok := err.(*url.Error); ok {
+		// the request URL may carry secrets, errors are logged and recorded
+		ue.URL = t.URL
+	}
+	return res, err
+}
+
+// respond derives the response to the sender from the outcomes
+func respond(outcomes []*Outcome, policy Policy) *Response {
+	switch policy {
+	case PolicyAccepted:
+		return &Response{StatusCode: http.StatusAccepted, Header: http.Header{}}
+	case PolicyAll:
+		var failed []string
+		for _, o := range outcomes {
+			if !o.Succeeded() {
+				failed = append(failed, o.Target)
+			}
+		}
+		if len(failed) > 0 {
+			return badGateway("Forwarding failed for " + strings.Join(failed, ", "))
+		}
+	}
+	if len(outcomes) == 0 {
+		return badGateway("No target to forward to")
+	}
+	primary := outcomes[0]
+	if primary.Err != nil {
+		return badGateway(primary.Err.Error())
+	}
+	return primary.Response
+}
+
+func badGateway(msg string) *Response {
+	h := http.Header{}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	return &Response{
+		StatusCode: http.StatusBadGateway,
+		Header:     h,
+		Body:       []byte(msg),
+	}
+}
+
+// Write writes the response to rw
+func (r *Response) Write(rw http.ResponseWriter) error {
+	for k, vv := range r.Header {
+		for _, v := range vv {
+			rw.Header().Add(k, v)
+		}
+	}
+	rw.WriteHeader(r.StatusCode)
+	_, err := bytes.NewReader(r.Body).WriteTo(rw)
+	return err
+}
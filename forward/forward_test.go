@@ -0,0 +1,1207 @@
+package forward
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newDelivery(t *testing.T) *Delivery {
+	req := httptest.NewRequest("POST", "/api/webhook?a=1", nil)
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("Connection", "close")
+	return NewDelivery(req, []byte(`{"ref":"refs/heads/master"}`))
+}
+
+func TestDelivery_request(t *testing.T) {
+	d := newDelivery(t)
+	target, _ := url.Parse("http://jenkins/prefix?b=2")
+	req, err := d.request(target, Target{Token: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.URL.String(), "http://jenkins/prefix/api/webhook?b=2&a=1"; got != want {
+		t.Errorf("request() URL = %v, want %v", got, want)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer t" {
+		t.Errorf("request() Authorization = %v", got)
+	}
+	if got := req.Header.Get("Connection"); got != "" {
+		t.Errorf("request() kept hop-by-hop header Connection = %v", got)
+	}
+	if got := req.Header.Get("X-Forwarded-For"); got != "192.0.2.1" {
+		t.Errorf("request() X-Forwarded-For = %v", got)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	if string(body) != `{"ref":"refs/heads/master"}` {
+		t.Errorf("request() body = %s", body)
+	}
+}
+
+func TestDelivery_request_Headers(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers HeaderPolicy
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "Sensitive Dropped", want: map[string]string{"X-GitHub-Event": "push", "Cookie": "", "Authorization": ""}},
+		{name: "Sensitive Allowed", headers: HeaderPolicy{Allow: []string{"cookie"}}, want: map[string]string{"Cookie": "a=1", "X-GitHub-Event": ""}},
+		{name: "Allow Prefix", headers: HeaderPolicy{Allow: []string{"X-GitHub-*"}}, want: map[string]string{"X-GitHub-Event": "push", "User-Agent": ""}},
+		{name: "Deny", headers: HeaderPolicy{Deny: []string{"User-Agent"}}, want: map[string]string{"X-GitHub-Event": "push", "User-Agent": ""}},
+		{name: "Set", headers: HeaderPolicy{Set: map[string]string{
+			"X-Delivery":        "{{.DeliveryID}}",
+			"X-Tenant":          "{{.Tenant}}",
+			"X-Event":           `{{.Header.Get "X-GitHub-Event"}}`,
+			"X-Forwarded-Proto": "https",
+		}}, want: map[string]string{"X-Delivery": "id", "X-Tenant": "tenant", "X-Event": "push", "X-Forwarded-Proto": "https"}},
+		{name: "Invalid Template", headers: HeaderPolicy{Set: map[string]string{"X-A": "{{.Unknown}}"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDelivery(t)
+			d.ID = "id"
+			d.Header.Set("Cookie", "a=1")
+			d.Header.Set("Authorization", "token secret")
+			d.Header.Set("User-Agent", "GitHub-Hookshot")
+			target, _ := url.Parse("http://jenkins")
+			req, err := d.request(target, Target{Headers: tt.headers, Tenant: "tenant"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("request() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for name, want := range tt.want {
+				if got := req.Header.Get(name); got != want {
+					t.Errorf("request() header %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Set("X-Token", "secret")
+	h.Set("X-GitHub-Event", "push")
+	got := Redact(h, "X-Token", "X-Missing")
+	if got.Get("Authorization") != "[REDACTED]" || got.Get("X-Token") != "[REDACTED]" {
+		t.Errorf("Redact() = %v, want the credentials redacted", got)
+	}
+	if got.Get("X-GitHub-Event") != "push" || got.Get("X-Missing") != "" {
+		t.Errorf("Redact() = %v, want the other headers unchanged", got)
+	}
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Errorf("Redact() changed the headers, Authorization = %v", h.Get("Authorization"))
+	}
+}
+
+func TestDelivery_request_Host(t *testing.T) {
+	d := newDelivery(t)
+	target, _ := url.Parse("http://10.0.0.1:8080")
+	req, err := d.request(target, Target{Headers: HeaderPolicy{Host: "jenkins.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Host != "jenkins.example.com" || req.URL.Host != "10.0.0.1:8080" {
+		t.Errorf("request() Host = %s, URL host %s", req.Host, req.URL.Host)
+	}
+}
+
+func Test_service_Forward(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	down, _ := url.Parse(failing.URL)
+	down.Host = "127.0.0.1:1"
+
+	tests := []struct {
+		name    string
+		targets []Target
+		policy  Policy
+		want    int
+	}{
+		{name: "Primary Succeeded", targets: []Target{{URL: ok.URL}, {URL: failing.URL}}, policy: PolicyPrimary, want: 200},
+		{name: "Primary Failed", targets: []Target{{URL: failing.URL}, {URL: ok.URL}}, policy: PolicyPrimary, want: 503},
+		{name: "Primary Unreachable", targets: []Target{{URL: down.String()}}, policy: PolicyPrimary, want: 502},
+		{name: "All Succeeded", targets: []Target{{URL: ok.URL}, {URL: ok.URL}}, policy: PolicyAll, want: 200},
+		{name: "All One Failed", targets: []Target{{URL: ok.URL}, {URL: failing.URL}}, policy: PolicyAll, want: 502},
+		{name: "Accepted", targets: []Target{{URL: failing.URL}}, policy: PolicyAccepted, want: 202},
+		{name: "No Target", policy: PolicyPrimary, want: 502},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{pool: newPool(0)}
+			res := s.Forward(newDelivery(t), tt.targets, tt.policy)
+			if len(res.Outcomes) != len(tt.targets) {
+				t.Errorf("service.Forward() outcomes = %d, want %d", len(res.Outcomes), len(tt.targets))
+			}
+			if res.Response.StatusCode != tt.want {
+				t.Errorf("service.Forward() status = %d, want %d", res.Response.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func Test_service_Dispatch(t *testing.T) {
+	received := make(chan string, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer target.Close()
+
+	s := &service{pool: newPool(0), queue: NewMemoryQueue(1)}
+	d := newDelivery(t)
+	res := s.Dispatch(d, []Target{{URL: target.URL}}, PolicyPrimary)
+	if res.Response.StatusCode != http.StatusAccepted {
+		t.Errorf("service.Dispatch() status = %d, want %d", res.Response.StatusCode, http.StatusAccepted)
+	}
+	if got := res.Response.Header.Get("X-Delivery-Id"); got == "" || got != d.ID {
+		t.Errorf("service.Dispatch() X-Delivery-Id = %v, want %v", got, d.ID)
+	}
+
+	res = s.Dispatch(newDelivery(t), []Target{{URL: target.URL}}, PolicyPrimary)
+	if res.Response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("service.Dispatch() on full queue status = %d, want %d", res.Response.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	go s.work()
+	if got := <-received; got != string(d.Body) {
+		t.Errorf("queued delivery body = %v, want %v", got, string(d.Body))
+	}
+	close(s.queue.(*memoryQueue).jobs)
+}
+
+func Test_service_Dispatch_SyncTimeout(t *testing.T) {
+	// the target is idled and never gets ready
+	jenkins := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/unidle" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer jenkins.Close()
+
+	tests := []struct {
+		name    string
+		target  Target
+		wantErr error
+	}{
+		{name: "Retry", target: Target{URL: jenkins.URL, Retry: Retry{MaxAttempts: 5, MaxAge: time.Minute}}},
+		{name: "Wake", target: Target{URL: jenkins.URL, Wake: Wake{UnidleURL: jenkins.URL + "/unidle"}}, wantErr: ErrWakeTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{
+				pool:        newPool(0),
+				syncTimeout: 100 * time.Millisecond,
+				backoff:     time.Second,
+				maxBackoff:  time.Second,
+				retry:       Retry{MaxAttempts: 1},
+				wakers:      &wakers{timeout: time.Minute, interval: 10 * time.Millisecond, inFlight: map[string]*waking{}},
+			}
+			start := time.Now()
+			res := s.Dispatch(newDelivery(t), []Target{tt.target}, PolicyPrimary)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("service.Dispatch() took %v, want at most the sync timeout", elapsed)
+			}
+			o := res.Outcomes[0]
+			if o.Attempts != 1 || o.Err != tt.wantErr {
+				t.Errorf("service.Dispatch() outcome = %d attempts %v, want 1 attempt %v", o.Attempts, o.Err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// resolver resolves the targets it knows with their token
+type resolver map[string]string
+
+func (r resolver) Resolve(t Target) (Target, error) {
+	token, ok := r[t.URL]
+	if !ok {
+		return t, fmt.Errorf("%s is no longer routed", t.URL)
+	}
+	t.Token = token
+	return t, nil
+}
+
+// letters collects the dead letters
+type letters struct {
+	lock    sync.Mutex
+	targets []Target
+}
+
+func (l *letters) Add(d *Delivery, t Target, o *Outcome) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.targets = append(l.targets, t)
+	return nil
+}
+
+func Test_service_process_Resolve(t *testing.T) {
+	auth := make(chan string, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth <- r.Header.Get("Authorization")
+	}))
+	defer target.Close()
+
+	dl := &letters{}
+	s := &service{pool: newPool(0), queue: NewMemoryQueue(1), deadLetters: dl,
+		resolver: resolver{target.URL: "current"}}
+	j := &Job{Delivery: newDelivery(t), Targets: []Target{{URL: target.URL}, {URL: "http://removed"}}}
+	s.process(j)
+	if got := <-auth; got != "Bearer current" {
+		t.Errorf("queued delivery Authorization = %v, want the resolved token", got)
+	}
+	if len(dl.targets) != 1 || dl.targets[0].URL != "http://removed" {
+		t.Errorf("dead letters = %+v, want the target no longer routed", dl.targets)
+	}
+}
+
+func Test_service_Dispatch_Debounce(t *testing.T) {
+	var lock sync.Mutex
+	var received []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		received = append(received, string(body))
+		lock.Unlock()
+	}))
+	defer target.Close()
+
+	s := &service{pool: newPool(0), debouncer: &debouncer{pending: map[string]*window{}}}
+	push := Target{URL: target.URL, Debounce: 100 * time.Millisecond,
+		Event: Event{Type: "push", GitURL: "https://github.com/fabric8-services/fabric8-webhook", Ref: "refs/heads/master"}}
+	deliveries := make([]*Delivery, 3)
+	results := make([]*Result, 3)
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		deliveries[i] = newDelivery(t)
+		deliveries[i].Body = []byte(fmt.Sprintf(`{"after":"%d"}`, i))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = s.Dispatch(deliveries[i], []Target{push}, PolicyPrimary)
+		}(i)
+		time.Sleep(10 * time.Millisecond)
+	}
+	// pull requests are not coalesced with the pushes to the branch
+	pr := push
+	pr.Event.PullRequest = 1
+	if res := s.Dispatch(newDelivery(t), []Target{pr}, PolicyPrimary); res.CoalescedInto != "" || len(res.Outcomes) != 1 {
+		t.Errorf("service.Dispatch() pull request = %+v, want forwarded", res)
+	}
+	// nor are the other events of the branch
+	create := push
+	create.Event.Type = "create"
+	if res := s.Dispatch(newDelivery(t), []Target{create}, PolicyPrimary); res.CoalescedInto != "" || len(res.Outcomes) != 1 {
+		t.Errorf("service.Dispatch() create = %+v, want forwarded", res)
+	}
+	wg.Wait()
+
+	for i, res := range results[:2] {
+		if res.CoalescedInto != deliveries[i+1].ID || res.Response.StatusCode != http.StatusAccepted || len(res.Outcomes) != 0 {
+			t.Errorf("service.Dispatch() superseded %d = %+v, want coalesced into %s", i, res, deliveries[i+1].ID)
+		}
+	}
+	if res := results[2]; res.CoalescedInto != "" || len(res.Outcomes) != 1 || res.Response.StatusCode != http.StatusOK {
+		t.Errorf("service.Dispatch() latest = %+v, want forwarded", res)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 3 || received[2] != `{"after":"2"}` {
+		t.Errorf("target received %v, want the pull request, the create then the latest push", received)
+	}
+}
+
+func Test_service_Dispatch_DebounceQueued(t *testing.T) {
+	received := make(chan string, 2)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer target.Close()
+
+	s := &service{pool: newPool(0), queue: NewMemoryQueue(2), debouncer: &debouncer{pending: map[string]*window{}}}
+	push := Target{URL: target.URL, Debounce: 50 * time.Millisecond,
+		Event: Event{Type: "push", GitURL: "https://github.com/fabric8-services/fabric8-webhook", Ref: "refs/heads/master"}}
+	first, latest := newDelivery(t), newDelivery(t)
+	first.Body, latest.Body = []byte("first"), []byte("latest")
+	for _, d := range []*Delivery{first, latest} {
+		if res := s.Dispatch(d, []Target{push}, PolicyPrimary); res.Response.StatusCode != http.StatusAccepted {
+			t.Fatalf("service.Dispatch() status = %d, want %d", res.Response.StatusCode, http.StatusAccepted)
+		}
+	}
+	// both are queued until the window closes
+	if n, _ := s.queue.Len(); n != 2 {
+		t.Errorf("queue length = %d, want 2", n)
+	}
+	if res := s.Dispatch(newDelivery(t), []Target{push}, PolicyPrimary); res.Response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("service.Dispatch() on full queue status = %d, want %d", res.Response.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	go s.work()
+	if got := <-received; got != "latest" {
+		t.Errorf("target received %v, want the latest push", got)
+	}
+	select {
+	case got := <-received:
+		t.Errorf("target received %v, want only the latest push", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// delayed pushes hold the queue lock
+	q := s.queue.(*memoryQueue)
+	q.lock.Lock()
+	close(q.jobs)
+	q.lock.Unlock()
+}
+
+func Test_service_Dispatch_Ordered(t *testing.T) {
+	var lock sync.Mutex
+	var received []string
+	failed := false
+	all := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, string(body))
+		if string(body) == "first" && !failed {
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if len(received) == 4 {
+			close(all)
+		}
+	}))
+	defer target.Close()
+
+	s := &service{
+		pool:       newPool(0),
+		queue:      NewMemoryQueue(10),
+		retry:      Retry{MaxAttempts: 3, MaxAge: time.Minute},
+		backoff:    50 * time.Millisecond,
+		maxBackoff: 50 * time.Millisecond,
+		sequencer:  &sequencer{waiting: map[string][]func(){}},
+		parked:     map[string]*Job{},
+	}
+	ordered := Target{URL: target.URL, Ordering: OrderRepository,
+		Event: Event{GitURL: "https://github.com/fabric8-services/fabric8-webhook"}}
+	other := ordered
+	other.Event.GitURL = "https://github.com/fabric8-services/fabric8-tenant"
+	for _, j := range []struct {
+		body   string
+		target Target
+	}{{"first", ordered}, {"second", ordered}, {"other", other}} {
+		d := newDelivery(t)
+		d.Body = []byte(j.body)
+		s.Dispatch(d, []Target{j.target}, PolicyPrimary)
+	}
+	for i := 0; i < 3; i++ {
+		go s.work()
+	}
+	select {
+	case <-all:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliveries not forwarded")
+	}
+	close(s.queue.(*memoryQueue).jobs)
+
+	lock.Lock()
+	defer lock.Unlock()
+	var repository []string
+	for _, body := range received {
+		if body != "other" {
+			repository = append(repository, body)
+		}
+	}
+	if got := strings.Join(repository, ","); got != "first,first,second" {
+		t.Errorf("target received %v, want first retried before second", received)
+	}
+	// the other repository is forwarded while the first delivery is retried
+	if received[3] == "other" {
+		t.Errorf("target received %v, want other before the retry", received)
+	}
+}
+
+// renewals counts the renewed leases of a memory queue
+type renewals struct {
+	Queue
+	renewed chan string
+}
+
+func (q *renewals) Renew(j *Job) error {
+	q.renewed <- j.Delivery.ID
+	return nil
+}
+
+func Test_service_renewEvery(t *testing.T) {
+	q := &renewals{Queue: NewMemoryQueue(1), renewed: make(chan string, 1)}
+	s := &service{queue: q, parked: map[string]*Job{}}
+	j := &Job{Delivery: newDelivery(t)}
+	s.park(j)
+	go s.renewEvery(time.Millisecond)
+	if got := <-q.renewed; got != j.Delivery.ID {
+		t.Errorf("renewed %v, want the parked job %v", got, j.Delivery.ID)
+	}
+}
+
+func Test_sequencer_run(t *testing.T) {
+	sq := &sequencer{waiting: map[string][]func(){}}
+	var lock sync.Mutex
+	var got []int
+	var wg sync.WaitGroup
+	release := make(chan struct{})
+	wg.Add(1)
+	// the first function holds the key until released
+	go sq.run("a", func() {
+		defer wg.Done()
+		<-release
+		lock.Lock()
+		got = append(got, 0)
+		lock.Unlock()
+	})
+	waiting := func(n int) {
+		for {
+			sq.lock.Lock()
+			w, ok := sq.waiting["a"]
+			sq.lock.Unlock()
+			if ok && len(w) == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waiting(0)
+	for i := 1; i < 5; i++ {
+		wg.Add(1)
+		go sq.run("a", func(i int) func() {
+			return func() {
+				defer wg.Done()
+				lock.Lock()
+				got = append(got, i)
+				lock.Unlock()
+			}
+		}(i))
+		// the callers wait in the order they called run
+		waiting(i)
+	}
+	close(release)
+	wg.Wait()
+	if fmt.Sprint(got) != "[0 1 2 3 4]" {
+		t.Errorf("sequencer.run() order = %v, want [0 1 2 3 4]", got)
+	}
+	time.Sleep(10 * time.Millisecond)
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+	if len(sq.waiting) != 0 {
+		t.Errorf("sequencer.waiting = %v, want empty", sq.waiting)
+	}
+}
+
+func Test_service_send_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retry        Retry
+		wantStatus   int
+		wantAttempts int
+	}{
+		{name: "Retried Until Success", statuses: []int{502, 503, 200}, retry: Retry{MaxAttempts: 5, MaxAge: time.Minute}, wantStatus: 200, wantAttempts: 3},
+		{name: "Max Attempts", statuses: []int{503, 503, 503}, retry: Retry{MaxAttempts: 2, MaxAge: time.Minute}, wantStatus: 503, wantAttempts: 2},
+		{name: "Max Age", statuses: []int{429, 200}, retry: Retry{MaxAttempts: 5, MaxAge: time.Nanosecond}, wantStatus: 429, wantAttempts: 1},
+		{name: "Not Retryable", statuses: []int{400, 200}, retry: Retry{MaxAttempts: 5, MaxAge: time.Minute}, wantStatus: 400, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[calls])
+				calls++
+			}))
+			defer target.Close()
+
+			s := &service{pool: newPool(0), backoff: time.Millisecond, maxBackoff: time.Millisecond}
+			d := newDelivery(t)
+			o := s.send(context.Background(), d, Target{URL: target.URL, Retry: tt.retry})
+			if o.Err != nil || o.Response.StatusCode != tt.wantStatus {
+				t.Errorf("service.send() = %v %v, want %d", o.Response, o.Err, tt.wantStatus)
+			}
+			if o.Attempts != tt.wantAttempts || len(d.Attempts()) != tt.wantAttempts {
+				t.Errorf("service.send() attempts = %d, recorded %d, want %d", o.Attempts, len(d.Attempts()), tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func Test_retryable(t *testing.T) {
+	tests := []struct {
+		name string
+		o    *Outcome
+		want bool
+	}{
+		{name: "Transport Error", o: &Outcome{Err: &url.Error{Op: "Post", URL: "http://jenkins", Err: fmt.Errorf("connection refused")}}, want: true},
+		{name: "Target Busy", o: &Outcome{Err: ErrTargetBusy}, want: true},
+		{name: "Wake Timeout", o: &Outcome{Err: ErrWakeTimeout}},
+		{name: "Build Error", o: &Outcome{Err: fmt.Errorf("template: job:1: unexpected EOF")}},
+		{name: "Transient Status", o: &Outcome{Response: &Response{StatusCode: 503}}, want: true},
+		{name: "Client Error Status", o: &Outcome{Response: &Response{StatusCode: 400}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.o); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_service_delay(t *testing.T) {
+	s := &service{backoff: time.Second, maxBackoff: 4 * time.Second}
+	tests := []struct {
+		name     string
+		header   string
+		attempt  int
+		min, max time.Duration
+	}{
+		{name: "First Retry", attempt: 1, min: 500 * time.Millisecond, max: time.Second},
+		{name: "Third Retry", attempt: 3, min: 2 * time.Second, max: 4 * time.Second},
+		{name: "Capped", attempt: 10, min: 2 * time.Second, max: 4 * time.Second},
+		{name: "Retry-After Seconds", header: "30", attempt: 1, min: 30 * time.Second, max: 30 * time.Second},
+		{name: "Retry-After Past Date", header: "Wed, 21 Oct 2015 07:28:00 GMT", attempt: 1, min: 0, max: 0},
+		{name: "Retry-After Invalid", header: "soon", attempt: 1, min: 500 * time.Millisecond, max: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &Outcome{Response: &Response{StatusCode: 503, Header: http.Header{}}}
+			if tt.header != "" {
+				o.Response.Header.Set("Retry-After", tt.header)
+			}
+			if got := s.delay(o, tt.attempt); got < tt.min || got > tt.max {
+				t.Errorf("service.delay() = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func Test_breaker(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings BreakerSettings
+		failures []bool
+		want     BreakerState
+	}{
+		{name: "Consecutive Failures", settings: BreakerSettings{Failures: 3, OpenDuration: time.Minute}, failures: []bool{true, true, true}, want: BreakerOpen},
+		{name: "Success Resets", settings: BreakerSettings{Failures: 3, OpenDuration: time.Minute}, failures: []bool{true, true, false, true, true}, want: BreakerClosed},
+		{name: "Error Rate", settings: BreakerSettings{Failures: 10, ErrorRate: 0.5, Window: 4, OpenDuration: time.Minute}, failures: []bool{true, false, true, false}, want: BreakerOpen},
+		{name: "Window Not Full", settings: BreakerSettings{Failures: 10, ErrorRate: 0.5, Window: 4, OpenDuration: time.Minute}, failures: []bool{true, false, true}, want: BreakerClosed},
+		{name: "Due For Probing", settings: BreakerSettings{Failures: 1}, failures: []bool{true}, want: BreakerHalfOpen},
+		{name: "Error Rate Only", settings: BreakerSettings{ErrorRate: 0.5, Window: 4, OpenDuration: time.Minute}, failures: []bool{true, false, true, false}, want: BreakerOpen},
+		{name: "Error Rate Only Below", settings: BreakerSettings{ErrorRate: 0.5, Window: 4, OpenDuration: time.Minute}, failures: []bool{true, false, false, false, true}, want: BreakerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker("test", tt.settings)
+			for _, f := range tt.failures {
+				b.Record(f)
+			}
+			if got := b.State(); got != tt.want {
+				t.Errorf("breaker.State() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_breakers_get(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings BreakerSettings
+		want     bool
+	}{
+		{name: "Disabled", settings: BreakerSettings{}},
+		{name: "Failures", settings: BreakerSettings{Failures: 3}, want: true},
+		{name: "Error Rate", settings: BreakerSettings{ErrorRate: 0.5, Window: 10}, want: true},
+		{name: "Error Rate Without Window", settings: BreakerSettings{ErrorRate: 0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bs := &breakers{settings: tt.settings, hosts: map[string]*breaker{}}
+			if got := bs.get("http://jenkins/github-webhook/") != nil; got != tt.want {
+				t.Errorf("breakers.get() != nil = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_breaker_HalfOpen(t *testing.T) {
+	b := newBreaker("test", BreakerSettings{Failures: 1})
+	b.Record(true)
+	if !b.Allow() {
+		t.Fatal("breaker.Allow() = false, want a probe")
+	}
+	if b.Allow() {
+		t.Error("breaker.Allow() during the probe = true, want false")
+	}
+	b.Record(false)
+	if got := b.State(); got != BreakerClosed {
+		t.Errorf("breaker.State() after successful probe = %v, want %v", got, BreakerClosed)
+	}
+}
+
+func Test_service_send_CircuitOpen(t *testing.T) {
+	calls := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer target.Close()
+
+	s := &service{
+		pool:     newPool(0),
+		breakers: &breakers{settings: BreakerSettings{Failures: 2, OpenDuration: time.Minute}, hosts: map[string]*breaker{}},
+	}
+	retry := Retry{MaxAttempts: 1, MaxAge: time.Minute}
+	for i := 0; i < 2; i++ {
+		s.send(context.Background(), newDelivery(t), Target{URL: target.URL, Retry: retry})
+	}
+	o := s.send(context.Background(), newDelivery(t), Target{URL: target.URL, Retry: retry})
+	if o.Err != ErrCircuitOpen || calls != 2 {
+		t.Errorf("service.send() on open circuit = %v after %d calls, want %v after 2", o.Err, calls, ErrCircuitOpen)
+	}
+	if states := s.Breakers(); len(states) != 1 || states[target.Listener.Addr().String()] != BreakerOpen {
+		t.Errorf("service.Breakers() = %v", states)
+	}
+}
+
+func Test_limiter_acquire(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		taken   int
+		wait    time.Duration
+		wantErr error
+	}{
+		{name: "In Flight Available", limits: Limits{MaxInFlight: 2}, taken: 1},
+		{name: "In Flight Exhausted", limits: Limits{MaxInFlight: 2}, taken: 2, wait: 10 * time.Millisecond, wantErr: ErrTargetBusy},
+		{name: "Burst", limits: Limits{Rate: 1, Burst: 3}, taken: 2},
+		{name: "Rate Exhausted", limits: Limits{Rate: 1}, taken: 1, wait: 10 * time.Millisecond, wantErr: ErrTargetBusy},
+		{name: "Rate Refilled In Time", limits: Limits{Rate: 100}, taken: 1, wait: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(tt.limits)
+			for i := 0; i < tt.taken; i++ {
+				if _, err := l.acquire(time.Now().Add(time.Second)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			release, err := l.acquire(time.Now().Add(tt.wait))
+			if err != tt.wantErr {
+				t.Errorf("limiter.acquire() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				release()
+			}
+		})
+	}
+}
+
+func Test_limiters_acquire_Reload(t *testing.T) {
+	ls := &limiters{maxWait: 10 * time.Millisecond, targets: map[string]*limiter{}}
+	tgt := Target{URL: "http://jenkins", Limits: Limits{MaxInFlight: 2}}
+	release, err := ls.acquire(context.Background(), tgt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the routes are reloaded with a lower limit, reached by
+	// the forward in progress
+	tgt.Limits.MaxInFlight = 1
+	if _, err := ls.acquire(context.Background(), tgt); err != ErrTargetBusy {
+		t.Errorf("limiters.acquire() after reload error = %v, want %v", err, ErrTargetBusy)
+	}
+	release()
+	if _, err := ls.acquire(context.Background(), tgt); err != nil {
+		t.Errorf("limiters.acquire() once released error = %v", err)
+	}
+}
+
+func Test_service_send_Limits(t *testing.T) {
+	var lock sync.Mutex
+	inFlight, max := 0, 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inFlight++
+		if inFlight > max {
+			max = inFlight
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+	}))
+	defer target.Close()
+
+	s := &service{pool: newPool(0), limiters: &limiters{maxWait: time.Second, targets: map[string]*limiter{}}}
+	tgt := Target{URL: target.URL, Retry: Retry{MaxAttempts: 1, MaxAge: time.Minute}, Limits: Limits{MaxInFlight: 2}}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if o := s.send(context.Background(), newDelivery(t), tgt); !o.Succeeded() {
+				t.Errorf("service.send() = %v %v", o.Response, o.Err)
+			}
+		}()
+	}
+	wg.Wait()
+	if max > 2 {
+		t.Errorf("%d forwards in flight, want at most 2", max)
+	}
+}
+
+func TestTransport_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport Transport
+		wantErr   bool
+	}{
+		{name: "Empty"},
+		{name: "Timeouts", transport: Transport{DialTimeout: time.Second, Timeout: time.Minute}},
+		{name: "Negative Timeout", transport: Transport{ResponseHeaderTimeout: -1}, wantErr: true},
+		{name: "Missing CA", transport: Transport{CAFile: "/nonexistent/ca.pem"}, wantErr: true},
+		{name: "Cert Without Key", transport: Transport{CertFile: "/nonexistent/cert.pem"}, wantErr: true},
+		{name: "Proxy", transport: Transport{Proxy: "http://proxy:3128"}},
+		{name: "Invalid Proxy", transport: Transport{Proxy: "proxy"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.transport.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Transport.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_service_attempt_Transport(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	ca, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: target.Certificate().Raw})
+	ca.Close()
+
+	s := &service{pool: newPool(0)}
+	if o := s.attempt(context.Background(), newDelivery(t), Target{URL: target.URL}); o.Err == nil {
+		t.Error("service.attempt() with the default transport succeeded, want an unknown authority error")
+	}
+	// the test certificate is valid for example.com
+	tgt := Target{URL: target.URL, Transport: Transport{CAFile: ca.Name(), ServerName: "example.com"}}
+	if o := s.attempt(context.Background(), newDelivery(t), tgt); !o.Succeeded() {
+		t.Errorf("service.attempt() with the CA of the target = %v %v", o.Response, o.Err)
+	}
+}
+
+func Test_pool_prepare(t *testing.T) {
+	p := newPool(2)
+	a, b := Target{URL: "http://a"}, Target{URL: "https://b", Transport: Transport{ServerName: "b"}}
+	if err := p.prepare([]Target{a, b}); err != nil {
+		t.Fatal(err)
+	}
+	ea, err := p.get(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := p.get(a); again != ea {
+		t.Error("pool.get() built the prepared endpoint again")
+	}
+	if err := p.prepare([]Target{a}); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.endpoints) != 1 || len(p.clients) != 1 {
+		t.Errorf("pool after prepare() has %d endpoints and %d clients, want 1 and 1", len(p.endpoints), len(p.clients))
+	}
+	if again, _ := p.get(a); again.client != ea.client {
+		t.Error("pool.prepare() replaced the client of an unchanged transport")
+	}
+	if err := p.prepare([]Target{{URL: "http://c", Transport: Transport{CAFile: "/nonexistent/ca.pem"}}}); err == nil {
+		t.Error("pool.prepare() with an invalid transport succeeded")
+	}
+}
+
+// benchmarkForward forwards bursts of concurrent deliveries to a target
+func benchmarkForward(b *testing.B, forward func(d *Delivery, targets []Target) *Result) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	defer target.Close()
+	req := httptest.NewRequest("POST", "/api/webhook", nil)
+	d := NewDelivery(req, []byte(`{"ref":"refs/heads/master"}`))
+	targets := []Target{{URL: target.URL, Retry: Retry{MaxAttempts: 1, MaxAge: time.Minute}}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if res := forward(d.Copy(), targets); res.Response.StatusCode != http.StatusOK {
+				b.Fatalf("Forward() = %d", res.Response.StatusCode)
+			}
+		}
+	})
+}
+
+func BenchmarkForward_Pooled(b *testing.B) {
+	s := &service{pool: newPool(16)}
+	benchmarkForward(b, func(d *Delivery, targets []Target) *Result {
+		return s.Forward(d, targets, PolicyPrimary)
+	})
+}
+
+// BenchmarkForward_Unpooled builds the target and its client on
+// every forward, as a reverse proxy per delivery does
+func BenchmarkForward_Unpooled(b *testing.B) {
+	benchmarkForward(b, func(d *Delivery, targets []Target) *Result {
+		s := &service{pool: newPool(16)}
+		defer s.pool.prepare(nil)
+		return s.Forward(d, targets, PolicyPrimary)
+	})
+}
+
+func TestCredentials_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials Credentials
+		wantErr     bool
+	}{
+		{name: "Empty"},
+		{name: "API Token", credentials: Credentials{User: "u", APIToken: "t", Crumb: true}},
+		{name: "Secret File", credentials: Credentials{User: "u", File: "/secret/token"}},
+		{name: "Bearer", credentials: Credentials{Token: "t"}},
+		{name: "API Token Without User", credentials: Credentials{APIToken: "t"}, wantErr: true},
+		{name: "Two Secrets", credentials: Credentials{User: "u", APIToken: "t", File: "/secret/token"}, wantErr: true},
+		{name: "Invalid Crumb Issuer", credentials: Credentials{Crumb: true, CrumbIssuer: "crumbs"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.credentials.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Credentials.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_service_attempt_Crumb(t *testing.T) {
+	// jenkins issues a crumb per session, sessions are
+	// dropped when it restarts
+	var lock sync.Mutex
+	sessions, issued := map[string]string{}, 0
+	jenkins := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if user, token, ok := r.BasicAuth(); !ok || user != "admin" || token != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/crumbIssuer/api/json" {
+			issued++
+			session := fmt.Sprintf("s%d", issued)
+			sessions[session] = "c" + session
+			http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: session})
+			fmt.Fprintf(w, `{"crumb":%q,"crumbRequestField":"Jenkins-Crumb"}`, sessions[session])
+			return
+		}
+		cookie, err := r.Cookie("JSESSIONID")
+		if err != nil || sessions[cookie.Value] == "" || r.Header.Get("Jenkins-Crumb") != sessions[cookie.Value] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}))
+	defer jenkins.Close()
+	secret, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(secret.Name())
+	secret.WriteString("secret\n")
+	secret.Close()
+
+	s := &service{pool: newPool(0)}
+	tgt := Target{URL: jenkins.URL + "/job/build", Credentials: Credentials{User: "admin", File: secret.Name(), Crumb: true}}
+	for i := 0; i < 2; i++ {
+		if o := s.attempt(context.Background(), newDelivery(t), tgt); !o.Succeeded() {
+			t.Fatalf("service.attempt() = %v %v", o.Response, o.Err)
+		}
+	}
+	lock.Lock()
+	sessions = map[string]string{}
+	lock.Unlock()
+	if o := s.attempt(context.Background(), newDelivery(t), tgt); !o.Succeeded() {
+		t.Fatalf("service.attempt() after restart = %v %v", o.Response, o.Err)
+	}
+	if issued != 2 {
+		t.Errorf("%d crumbs issued, want 2", issued)
+	}
+}
+
+func Test_service_attempt_Wake(t *testing.T) {
+	tests := []struct {
+		name      string
+		statusAPI bool
+	}{
+		{name: "Idled On 503"},
+		{name: "Idler Status API", statusAPI: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lock sync.Mutex
+			idled, unidles, forwards := true, 0, 0
+			jenkins := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+				switch r.URL.Path {
+				case "/idler/status/tenant":
+					fmt.Fprintf(w, `{"data":{"is_idle":%t}}`, idled)
+				case "/idler/unidle/tenant":
+					unidles++
+					idled = false
+				default:
+					if idled {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					if r.URL.Path != "/" {
+						forwards++
+					}
+				}
+			}))
+			defer jenkins.Close()
+
+			s := &service{pool: newPool(0), wakers: &wakers{timeout: time.Second, interval: 10 * time.Millisecond, inFlight: map[string]*waking{}}}
+			wake := Wake{UnidleURL: jenkins.URL + "/idler/unidle/{{.Tenant}}"}
+			if tt.statusAPI {
+				wake.StatusURL = jenkins.URL + "/idler/status/{{.Tenant}}"
+			}
+			tgt := Target{URL: jenkins.URL + "/github-webhook/", Tenant: "tenant", Wake: wake}
+			var wg sync.WaitGroup
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if o := s.attempt(context.Background(), newDelivery(t), tgt); !o.Succeeded() {
+						t.Errorf("service.attempt() = %v %v", o.Response, o.Err)
+					}
+				}()
+			}
+			wg.Wait()
+			if unidles != 1 || forwards != 3 {
+				t.Errorf("target unidled %d times and got %d forwards, want 1 and 3", unidles, forwards)
+			}
+		})
+	}
+}
+
+func TestWake_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		wake    Wake
+		wantErr bool
+	}{
+		{name: "Disabled"},
+		{name: "Unidle", wake: Wake{UnidleURL: "http://idler/api/idler/unidle/{{.Tenant}}", StatusURL: "http://idler/api/idler/status/{{.Tenant}}"}},
+		{name: "Status Without Unidle", wake: Wake{StatusURL: "http://idler/api/idler/status/{{.Tenant}}"}, wantErr: true},
+		{name: "Invalid Template", wake: Wake{UnidleURL: "http://idler/{{.Namespace}}"}, wantErr: true},
+		{name: "Invalid Idled Path", wake: Wake{UnidleURL: "http://idler", IdledPath: "data"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.wake.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Wake.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTrigger_url(t *testing.T) {
+	data := (&Delivery{URL: &url.URL{}}).headerData(Target{Event: Event{
+		GitURL:      "https://github.com/fabric8-services/fabric8-webhook.git",
+		Ref:         "refs/heads/feature/x y",
+		Commit:      "bffeb74",
+		PullRequest: 12,
+	}})
+	tests := []struct {
+		name    string
+		trigger Trigger
+		want    string
+	}{
+		{name: "Scan", trigger: Trigger{Job: "{{.Name}}", Scan: true}, want: "http://jenkins/root/job/fabric8-webhook/build?delay=0&token=1"},
+		{name: "Build", trigger: Trigger{Job: "folder/{{.Name}}"}, want: "http://jenkins/root/job/folder/job/fabric8-webhook/build?token=1"},
+		{name: "Build With Parameters", trigger: Trigger{Job: "{{.Name}}/{{.Branch}}", Parameters: map[string]string{
+			"SHA": "{{.Commit}}",
+			"PR":  "{{.PullRequest}}",
+		}}, want: "http://jenkins/root/job/fabric8-webhook/job/feature%2Fx%20y/buildWithParameters?PR=12&SHA=bffeb74&token=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jenkins, _ := url.Parse("http://jenkins/root/?token=1")
+			got, err := tt.trigger.url(jenkins, data)
+			if err != nil || got != tt.want {
+				t.Errorf("Trigger.url() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	jenkins, _ := url.Parse("http://jenkins")
+	if _, err := (Trigger{Job: "{{.Name}}/{{.PullRequest}}"}).url(jenkins, (&Delivery{URL: &url.URL{}}).headerData(Target{})); err == nil {
+		t.Errorf("Trigger.url() with empty folder error = nil, want an error")
+	}
+}
+
+func Test_service_attempt_Trigger(t *testing.T) {
+	jenkins := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/job/app/buildWithParameters" || r.URL.Query().Get("SHA") != "bffeb74" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Location", "http://jenkins/queue/item/42/")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer jenkins.Close()
+
+	s := &service{pool: newPool(0)}
+	d := newDelivery(t)
+	tgt := Target{
+		URL:     jenkins.URL,
+		Trigger: Trigger{Job: "{{.Name}}", Parameters: map[string]string{"SHA": "{{.Commit}}"}},
+		Event:   Event{GitURL: "https://github.com/org/app.git", Commit: "bffeb74"},
+	}
+	o := s.attempt(context.Background(), d, tgt)
+	if !o.Succeeded() {
+		t.Fatalf("service.attempt() = %v %v", o.Response, o.Err)
+	}
+	d.record(o)
+	if a := d.Attempts(); a[0].Location != "http://jenkins/queue/item/42/" {
+		t.Errorf("recorded attempt location = %q", a[0].Location)
+	}
+}
+
+func Test_service_attempt_Types(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   Target
+		wantPath string
+		wantBody string
+	}{
+		{name: "Tekton", target: Target{Type: TypeTekton}, wantPath: "/listener", wantBody: `{"ref":"refs/heads/master"}`},
+		{name: "BuildConfig GitHub", target: Target{Type: TypeBuildConfig, BuildConfig: BuildConfig{Secret: "s3cr3t"}},
+			wantPath: "/listener/s3cr3t/github", wantBody: `{"ref":"refs/heads/master"}`},
+		{name: "BuildConfig Generic", target: Target{Type: TypeBuildConfig, BuildConfig: BuildConfig{Secret: "s3cr3t", Generic: true},
+			Event: Event{GitURL: "https://github.com/org/app.git", Ref: "refs/heads/master", Commit: "bffeb74"}},
+			wantPath: "/listener/s3cr3t/generic", wantBody: `{"type":"Git","git":{"uri":"https://github.com/org/app.git","ref":"master","commit":"bffeb74"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path, event string
+			var body []byte
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, event = r.URL.Path, r.Header.Get("X-GitHub-Event")
+				body, _ = ioutil.ReadAll(r.Body)
+			}))
+			defer target.Close()
+
+			s := &service{pool: newPool(0)}
+			tt.target.URL = target.URL + "/listener"
+			if o := s.attempt(context.Background(), newDelivery(t), tt.target); !o.Succeeded() {
+				t.Fatalf("service.attempt() = %v %v", o.Response, o.Err)
+			}
+			if path != tt.wantPath || string(body) != tt.wantBody {
+				t.Errorf("target got %s %s, want %s %s", path, body, tt.wantPath, tt.wantBody)
+			}
+			if !tt.target.BuildConfig.Generic && event != "push" {
+				t.Errorf("target got X-GitHub-Event %q, want push", event)
+			}
+		})
+	}
+}
+
+func Test_service_attempt_SecretRedacted(t *testing.T) {
+	s := &service{pool: newPool(0)}
+	tgt := Target{URL: "http://127.0.0.1:1/webhooks", Type: TypeBuildConfig, BuildConfig: BuildConfig{Secret: "s3cr3t"}}
+	if o := s.attempt(context.Background(), newDelivery(t), tgt); o.Err == nil || strings.Contains(o.Err.Error(), "s3cr3t") {
+		t.Errorf("service.attempt() error = %v, want an error without the secret", o.Err)
+	}
+}
+
+func TestTransform_render(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform Transform
+		want      string
+		wantErr   bool
+	}{
+		{name: "Template", transform: Transform{Body: `{"repo":{{jsonpath "$.repository.full_name" .Payload | json}},"branch":{{json .Branch}},"sha":"{{.Commit}}"}`},
+			want: `{"repo":"fabric8-services/fabric8-webhook","branch":"master","sha":"bffeb74224043ba2feb48d137756c8a9331c449a"}`},
+		{name: "Projection", transform: Transform{Projection: map[string]string{"sha": "$.after", "pusher": "$.pusher.name", "missing": "$.none"}},
+			want: `{"missing":null,"pusher":"octocat","sha":"bffeb74224043ba2feb48d137756c8a9331c449a"}`},
+		{name: "Unknown Field", transform: Transform{Body: `{{.Sha}}`}, wantErr: true},
+		{name: "Invalid JSONPath", transform: Transform{Projection: map[string]string{"sha": "after"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.transform.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Transform.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := tt.transform.render(sampleData())
+			if err != nil || string(got) != tt.want {
+				t.Errorf("Transform.render() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_service_Preview(t *testing.T) {
+	s := &service{pool: newPool(0)}
+	d := newDelivery(t)
+	previews := s.Preview(d, []Target{
+		{URL: "http://jenkins", Token: "t", Transform: Transform{Body: `{"sha":"{{.Commit}}"}`}, Event: Event{Commit: "bffeb74"}},
+		{URL: "http://openshift/webhooks", Type: TypeBuildConfig, BuildConfig: BuildConfig{Secret: "s3cr3t"}},
+		{URL: "http://jenkins", Headers: HeaderPolicy{Set: map[string]string{"X-A": "{{.Unknown}}"}}},
+		{URL: "http://jenkins/?token=s3cr3t", Trigger: Trigger{Job: "app", Parameters: map[string]string{"SHA": "{{.Commit}}"}}, Event: Event{Commit: "bffeb74"}},
+	})
+	if len(previews) != 4 {
+		t.Fatalf("service.Preview() = %d previews, want 4", len(previews))
+	}
+	if p := previews[0]; p.Err != nil || p.URL != "http://jenkins/api/webhook?a=1" || string(p.Body) != `{"sha":"bffeb74"}` ||
+		p.Header.Get("Authorization") != redacted || p.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("service.Preview() transformed = %+v", p)
+	}
+	if p := previews[1]; p.Err != nil || p.URL != "http://openshift/webhooks/[REDACTED]/github" {
+		t.Errorf("service.Preview() buildconfig = %+v", p)
+	}
+	if p := previews[2]; p.Err == nil {
+		t.Errorf("service.Preview() invalid header template = %+v, want an error", p)
+	}
+	if p := previews[3]; p.Err != nil || p.URL != "http://jenkins/job/app/buildWithParameters?SHA=bffeb74&token=[REDACTED]" {
+		t.Errorf("service.Preview() trigger = %+v, want the token redacted", p)
+	}
+}
@@ -0,0 +1,196 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// sensitiveHeaders are dropped from the forwarded requests
+// unless the header policy allows them explicitly
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// redacted replaces the values of secrets
+const redacted = "[REDACTED]"
+
+// Redact returns a copy of the headers with the values of the
+// sensitive headers and of the headers names replaced
+func Redact(h http.Header, names ...string) http.Header {
+	res := http.Header{}
+	for name, values := range h {
+		res[name] = values
+	}
+	for _, name := range append(sensitiveHeaders, names...) {
+		if res.Get(name) != "" {
+			res.Set(name, redacted)
+		}
+	}
+	return res
+}
+
+// HeaderPolicy defines the headers of the requests forwarded to a target
+type HeaderPolicy struct {
+	// Allow lists the inbound headers forwarded, all if empty.
+	// A trailing * matches any suffix e.g. X-GitHub-*
+	Allow []string `mapstructure:"allow"`
+	// Deny lists the inbound headers never forwarded
+	Deny []string `mapstructure:"deny"`
+	// Set are headers added to the forwarded requests, their values
+	// are templates of HeaderData e.g. {{.DeliveryID}}
+	Set map[string]string `mapstructure:"set"`
+	// Host replaces the Host header, the host of the target if empty
+	Host string `mapstructure:"host"`
+}
+
+// HeaderData is the data of the templates of the set headers,
+// of the wake URLs and of the triggers
+type HeaderData struct {
+	DeliveryID string
+	Tenant     string
+	// GitURL and Name are the URL and the name of the repository
+	GitURL string
+	Name   string
+	// Ref is the fully qualified ref and Branch its short name
+	Ref         string
+	Branch      string
+	Commit      string
+	PullRequest string
+	// ClientIP is the IP of the sender of the delivery
+	ClientIP string
+	Method   string
+	Path     string
+	// Header are the inbound headers e.g. {{.Header.Get "X-GitHub-Event"}}
+	Header http.Header
+
+	body    []byte
+	decoded bool
+	payload interface{}
+}
+
+// Payload returns the JSON payload of the delivery decoded by
+// encoding/json, nil if not JSON e.g. {{.Payload.repository.name}}
+func (h *HeaderData) Payload() interface{} {
+	if !h.decoded {
+		h.decoded = true
+		if err := json.Unmarshal(h.body, &h.payload); err != nil {
+			h.payload = nil
+		}
+	}
+	return h.payload
+}
+
+// Validate checks the templates of the set headers and of the host
+// render the sample payload, so unknown fields are reported when
+// loading the policy
+func (p HeaderPolicy) Validate() error {
+	req := &http.Request{Header: http.Header{}}
+	return p.apply(req, sampleData())
+}
+
+// filter removes the inbound headers the policy does not forward
+func (p HeaderPolicy) filter(h http.Header) {
+	for name := range h {
+		if !p.allowed(name) {
+			h.Del(name)
+		}
+	}
+}
+
+func (p HeaderPolicy) allowed(name string) bool {
+	if matchHeader(p.Deny, name) {
+		return false
+	}
+	if len(p.Allow) > 0 {
+		return matchHeader(p.Allow, name)
+	}
+	return !matchHeader(sensitiveHeaders, name)
+}
+
+// apply sets the headers and the host of the policy on req
+func (p HeaderPolicy) apply(req *http.Request, data *HeaderData) error {
+	for name, value := range p.Set {
+		v, err := execTemplate(value, data)
+		if err != nil {
+			return fmt.Errorf("header %s: %v", name, err)
+		}
+		req.Header.Set(name, v)
+	}
+	if p.Host != "" {
+		host, err := execTemplate(p.Host, data)
+		if err != nil {
+			return fmt.Errorf("host: %v", err)
+		}
+		req.Host = host
+	}
+	return nil
+}
+
+// matchHeader tells whether name matches any of the patterns
+func matchHeader(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(strings.ToLower(name), strings.ToLower(strings.TrimSuffix(p, "*"))) {
+				return true
+			}
+		} else if strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// templates caches the parsed header templates by text
+var templates sync.Map
+
+func parseTemplate(text string) (*template.Template, error) {
+	if t, ok := templates.Load(text); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("header").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	templates.Store(text, t)
+	return t, nil
+}
+
+func execTemplate(text string, data *HeaderData) (string, error) {
+	t, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// headerData returns the data of the header templates of the
+// delivery forwarded to t
+func (d *Delivery) headerData(t Target) *HeaderData {
+	ip, _, err := net.SplitHostPort(d.RemoteAddr)
+	if err != nil {
+		ip = d.RemoteAddr
+	}
+	return &HeaderData{
+		DeliveryID:  d.ID,
+		Tenant:      t.Tenant,
+		GitURL:      t.Event.GitURL,
+		Name:        t.Event.name(),
+		Ref:         t.Event.Ref,
+		Branch:      t.Event.branch(),
+		Commit:      t.Event.Commit,
+		PullRequest: t.Event.pullRequest(),
+		ClientIP:    ip,
+		Method:      d.Method,
+		Path:        d.URL.Path,
+		Header:      d.Header,
+		body:        d.Body,
+	}
+}
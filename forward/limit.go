@@ -0,0 +1,193 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTargetBusy is the error of attempts which could not get through
+// the limits of the target before the wait deadline
+var ErrTargetBusy = errors.New("Target is busy, limits not available before the deadline")
+
+// Limits cap the load put on a target, zero values disable the limit
+type Limits struct {
+	// MaxInFlight is the number of forwards in progress to the target
+	MaxInFlight int `mapstructure:"max_in_flight"`
+	// Rate is the number of forwards per second to the target
+	Rate float64 `mapstructure:"rate"`
+	// Burst is the number of forwards the rate lets through at once,
+	// defaults to 1
+	Burst int `mapstructure:"burst"`
+	// MaxWait is how long a forward waits for the limits, falls back
+	// to the configured default
+	MaxWait time.Duration `mapstructure:"max_wait"`
+}
+
+// Validate checks the limits are not negative
+func (l Limits) Validate() error {
+	if l.MaxInFlight < 0 || l.Rate < 0 || l.Burst < 0 || l.MaxWait < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+// enabled tells whether any limit is set
+func (l Limits) enabled() bool {
+	return l.MaxInFlight > 0 || l.Rate > 0
+}
+
+// burst is the number of forwards the rate lets through at once
+func (l Limits) burst() int {
+	if l.Burst == 0 {
+		return 1
+	}
+	return l.Burst
+}
+
+// limiter enforces the limits of a target
+type limiter struct {
+	lock   sync.Mutex
+	limits Limits
+	// inFlight is the number of forwards in progress,
+	// kept when the limits change
+	inFlight int
+	// freed is closed when a forward in progress ends
+	freed  chan struct{}
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(l Limits) *limiter {
+	return &limiter{
+		limits: l,
+		freed:  make(chan struct{}),
+		tokens: float64(l.burst()),
+		last:   time.Now(),
+	}
+}
+
+// set changes the limits, the forwards in progress
+// count against the new ones
+func (l *limiter) set(limits Limits) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.limits = limits
+	if max := float64(limits.burst()); l.tokens > max {
+		l.tokens = max
+	}
+}
+
+// acquire waits until the limits let a forward through or the deadline
+// passes. The returned function ends the forward in progress.
+func (l *limiter) acquire(deadline time.Time) (func(), error) {
+	var timer *time.Timer
+	for {
+		l.lock.Lock()
+		if max := l.limits.MaxInFlight; max == 0 || l.inFlight < max {
+			l.inFlight++
+			l.lock.Unlock()
+			break
+		}
+		freed := l.freed
+		l.lock.Unlock()
+		if timer == nil {
+			timer = time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+		}
+		select {
+		case <-freed:
+		case <-timer.C:
+			return nil, ErrTargetBusy
+		}
+	}
+	if err := l.take(deadline); err != nil {
+		l.release()
+		return nil, err
+	}
+	return l.release, nil
+}
+
+// release ends a forward in progress
+func (l *limiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.inFlight--
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// take reserves a token of the bucket, waiting for it unless
+// it would only be available after the deadline
+func (l *limiter) take(deadline time.Time) error {
+	l.lock.Lock()
+	if l.limits.Rate <= 0 {
+		l.lock.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.limits.Rate
+	if max := float64(l.limits.burst()); l.tokens > max {
+		l.tokens = max
+	}
+	l.last = now
+	var wait time.Duration
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.limits.Rate * float64(time.Second))
+	}
+	if wait > 0 && now.Add(wait).After(deadline) {
+		l.lock.Unlock()
+		return ErrTargetBusy
+	}
+	// the token is reserved even if not refilled yet, later
+	// forwards wait for the following ones
+	l.tokens--
+	l.lock.Unlock()
+	time.Sleep(wait)
+	return nil
+}
+
+// limiters holds the limiter of every limited target. Routes
+// forwarding to the same target share its limits.
+type limiters struct {
+	lock    sync.Mutex
+	maxWait time.Duration
+	targets map[string]*limiter
+}
+
+// acquire waits for the limits of the target, no later than the
+// deadline of ctx. The returned function releases them.
+func (ls *limiters) acquire(ctx context.Context, t Target) (func(), error) {
+	if ls == nil {
+		return func() {}, nil
+	}
+	ls.lock.Lock()
+	l, ok := ls.targets[t.URL]
+	switch {
+	case !ok && !t.Limits.enabled():
+		ls.lock.Unlock()
+		return func() {}, nil
+	case !ok:
+		l = newLimiter(t.Limits)
+		ls.targets[t.URL] = l
+	default:
+		// routes may have been reloaded with other limits
+		l.set(t.Limits)
+	}
+	ls.lock.Unlock()
+	wait := t.Limits.MaxWait
+	if wait == 0 {
+		wait = ls.maxWait
+	}
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	release, err := l.acquire(deadline)
+	if err != nil {
+		limitRejections.WithLabelValues(t.URL).Inc()
+	}
+	return release, err
+}
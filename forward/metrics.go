@@ -0,0 +1,97 @@
+package forward
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "queue_depth",
+		Help:      "Number of deliveries waiting for a worker.",
+	})
+	queueCapacity = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "queue_capacity",
+		Help:      "Number of deliveries which can wait for a worker.",
+	})
+	workers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "workers",
+		Help:      "Number of workers forwarding queued deliveries.",
+	})
+	busyWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "busy_workers",
+		Help:      "Number of workers currently forwarding a delivery.",
+	})
+	outcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "outcomes_total",
+		Help:      "Outcomes of forwarding deliveries to targets.",
+	}, []string{"result"})
+	retries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "retries_total",
+		Help:      "Number of retried forwards.",
+	})
+	breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "breaker_state",
+		Help:      "State of the circuit breaker of target hosts, 1 for the current state.",
+	}, []string{"host", "state"})
+	breakerRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "breaker_rejections_total",
+		Help:      "Number of attempts failed fast by the circuit breaker of target hosts.",
+	}, []string{"host"})
+	limitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "limit_rejections_total",
+		Help:      "Number of attempts which did not get through the limits of targets before the deadline.",
+	}, []string{"target"})
+	wakeups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "wakeups_total",
+		Help:      "Number of wake-ups of idled targets by result.",
+	}, []string{"result"})
+	wakeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "wakeup_duration_seconds",
+		Help:      "Time from unidling a target until it is ready.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+	coalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "fabric8_webhook",
+		Subsystem: "forward",
+		Name:      "coalesced_total",
+		Help:      "Number of pushes superseded by a later push within the debounce window.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, queueCapacity,
+		workers, busyWorkers, outcomes, retries,
+		breakerState, breakerRejections, limitRejections,
+		wakeups, wakeDuration, coalesced)
+}
+
+func recordOutcome(o *Outcome) {
+	switch {
+	case o.Err != nil:
+		outcomes.WithLabelValues("error").Inc()
+	case o.Succeeded():
+		outcomes.WithLabelValues("success").Inc()
+	default:
+		outcomes.WithLabelValues("failure").Inc()
+	}
+}
@@ -0,0 +1,94 @@
+package forward
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Ordering defines which deliveries are forwarded serially, in
+// arrival order. Deliveries of other repositories proceed in parallel.
+type Ordering string
+
+const (
+	// OrderNone forwards the deliveries concurrently
+	OrderNone Ordering = ""
+	// OrderRepository forwards the deliveries of a repository serially
+	OrderRepository Ordering = "repository"
+	// OrderRef forwards the deliveries of a ref of a repository serially
+	OrderRef Ordering = "ref"
+)
+
+// Validate checks the ordering is known
+func (o Ordering) Validate() error {
+	switch o {
+	case OrderNone, OrderRepository, OrderRef:
+		return nil
+	}
+	return fmt.Errorf("unknown ordering %q", o)
+}
+
+// orderKey returns the key of the deliveries forwarded serially with
+// the delivery to the targets, empty if the delivery is not ordered
+func orderKey(targets []Target) string {
+	if len(targets) == 0 {
+		return ""
+	}
+	e := targets[0].Event
+	switch targets[0].Ordering {
+	case OrderRepository:
+		return e.GitURL
+	case OrderRef:
+		return e.GitURL + " " + e.Ref
+	}
+	return ""
+}
+
+// sequencer runs the functions of a key one at a time in the order
+// they were added. It orders the deliveries forwarded by an instance,
+// durable queues shared by replicas order them across instances.
+type sequencer struct {
+	lock sync.Mutex
+	// waiting holds the functions of every key with one running
+	waiting map[string][]func()
+}
+
+// add queues f behind the running function of key. If none is
+// running it returns true, the caller then runs f and calls next.
+func (sq *sequencer) add(key string, f func()) bool {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+	if w, ok := sq.waiting[key]; ok {
+		sq.waiting[key] = append(w, f)
+		return false
+	}
+	sq.waiting[key] = nil
+	return true
+}
+
+// next returns the following function of key once the running one
+// returned, the caller runs it and calls next again. It returns nil
+// once none is waiting.
+func (sq *sequencer) next(key string) func() {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+	w := sq.waiting[key]
+	if len(w) == 0 {
+		delete(sq.waiting, key)
+		return nil
+	}
+	sq.waiting[key] = w[1:]
+	return w[0]
+}
+
+// run runs f once the functions of key added before returned. The
+// caller waits for its turn, which the previous caller hands over.
+func (sq *sequencer) run(key string, f func()) {
+	turn := make(chan struct{})
+	if !sq.add(key, func() { close(turn) }) {
+		<-turn
+	}
+	f()
+	if next := sq.next(key); next != nil {
+		next()
+	}
+}
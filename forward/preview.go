@@ -0,0 +1,79 @@
+package forward
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Preview is the request a delivery would be sent to a target with.
+// Credentials are not added and secrets are redacted.
+type Preview struct {
+	Target string
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+	// Err is the error rendering the request
+	Err error
+}
+
+func (s *service) Preview(d *Delivery, targets []Target) []*Preview {
+	var previews []*Preview
+	for _, t := range targets {
+		previews = append(previews, s.preview(d, t))
+	}
+	return previews
+}
+
+func (s *service) preview(d *Delivery, t Target) *Preview {
+	p := &Preview{Target: t.URL}
+	e, err := s.pool.get(t)
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	req, err := d.build(e.url, t)
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	p.Method = req.Method
+	p.URL = redactQuery(req.URL, e.url)
+	if secret, err := t.BuildConfig.secret(); err == nil && secret != "" {
+		p.URL = strings.Replace(p.URL, secret, redacted, -1)
+	}
+	p.Header = req.Header
+	if p.Header.Get("Authorization") != "" {
+		p.Header.Set("Authorization", redacted)
+	}
+	if req.Host != "" && req.Host != req.URL.Host {
+		p.Header.Set("Host", req.Host)
+	}
+	if req.Body != nil {
+		p.Body, p.Err = ioutil.ReadAll(req.Body)
+	}
+	return p
+}
+
+// redactQuery returns u with the values of the query parameters of
+// the target redacted, they may authenticate e.g. a trigger token
+func redactQuery(u, target *url.URL) string {
+	secrets := target.Query()
+	if len(secrets) == 0 {
+		return u.String()
+	}
+	r := *u
+	params := strings.Split(r.RawQuery, "&")
+	for i, param := range params {
+		name := strings.SplitN(param, "=", 2)[0]
+		if n, err := url.QueryUnescape(name); err == nil {
+			if _, ok := secrets[n]; ok {
+				params[i] = name + "=" + redacted
+			}
+		}
+	}
+	r.RawQuery = strings.Join(params, "&")
+	return r.String()
+}
@@ -0,0 +1,117 @@
+package forward
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when the queue cannot take more deliveries
+	ErrQueueFull = errors.New("Delivery queue is full")
+	// ErrQueueClosed is returned by Pop once the queue is closed
+	ErrQueueClosed = errors.New("Delivery queue is closed")
+)
+
+// Job is a delivery queued for asynchronous forwarding
+type Job struct {
+	Delivery *Delivery
+	Targets  []Target
+	// NotBefore is when the job may be popped, e.g. once its debounce
+	// window closed, zero if right away
+	NotBefore time.Time
+}
+
+// OrderKey returns the key of the jobs forwarded serially with
+// the job, empty if it is not ordered
+func (j *Job) OrderKey() string {
+	return orderKey(j.Targets)
+}
+
+// Queue holds deliveries waiting for a worker. A durable queue
+// keeps them across restarts.
+type Queue interface {
+	// Push adds the job or returns ErrQueueFull
+	Push(j *Job) error
+	// Pop waits for the next job. Durable queues, which replicas
+	// share, pop the jobs of an order key one at a time in order:
+	// a job is not popped until the previous ones of its key are done.
+	Pop() (*Job, error)
+	// Done is called once the job is forwarded
+	Done(j *Job, res *Result) error
+	// Renew extends the lease of the popped job, e.g. while it
+	// waits for the previous ordered jobs
+	Renew(j *Job) error
+	// Len returns the number of waiting jobs
+	Len() (int, error)
+}
+
+// memoryQueue is a bounded in-memory queue, its jobs are lost on restart
+type memoryQueue struct {
+	lock sync.Mutex
+	jobs chan *Job
+	// delayed counts the jobs waiting for their NotBefore,
+	// their room in jobs is reserved
+	delayed int
+	// coalesced are the IDs of the jobs completed while queued
+	coalesced map[string]bool
+}
+
+// NewMemoryQueue returns a Queue holding up to size jobs in memory
+func NewMemoryQueue(size int) Queue {
+	return &memoryQueue{jobs: make(chan *Job, size), coalesced: map[string]bool{}}
+}
+
+func (q *memoryQueue) Push(j *Job) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.jobs)+q.delayed >= cap(q.jobs) {
+		return ErrQueueFull
+	}
+	if wait := time.Until(j.NotBefore); wait > 0 {
+		q.delayed++
+		time.AfterFunc(wait, func() {
+			q.lock.Lock()
+			defer q.lock.Unlock()
+			q.delayed--
+			q.jobs <- j
+		})
+		return nil
+	}
+	q.jobs <- j
+	return nil
+}
+
+func (q *memoryQueue) Pop() (*Job, error) {
+	for j := range q.jobs {
+		q.lock.Lock()
+		skip := q.coalesced[j.Delivery.ID]
+		delete(q.coalesced, j.Delivery.ID)
+		q.lock.Unlock()
+		if !skip {
+			return j, nil
+		}
+	}
+	return nil, ErrQueueClosed
+}
+
+// Done drops the jobs coalesced while queued
+func (q *memoryQueue) Done(j *Job, res *Result) error {
+	if res.CoalescedInto != "" {
+		q.lock.Lock()
+		q.coalesced[j.Delivery.ID] = true
+		q.lock.Unlock()
+	}
+	return nil
+}
+
+// Renew does nothing, popped jobs are not claimed again
+func (q *memoryQueue) Renew(j *Job) error {
+	return nil
+}
+
+func (q *memoryQueue) Len() (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.jobs) + q.delayed, nil
+}
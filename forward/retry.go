@@ -0,0 +1,120 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Retry caps retries of failed forwards, zero values
+// fall back to the configured defaults
+type Retry struct {
+	// MaxAttempts is the number of attempts including the first one
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// MaxAge is the duration after which a delivery is no longer retried
+	MaxAge time.Duration `mapstructure:"max_age"`
+}
+
+// Validate checks the caps are not negative
+func (r Retry) Validate() error {
+	if r.MaxAttempts < 0 || r.MaxAge < 0 {
+		return fmt.Errorf("retry caps must not be negative")
+	}
+	return nil
+}
+
+// or returns r with unset caps taken from def
+func (r Retry) or(def Retry) Retry {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = def.MaxAttempts
+	}
+	if r.MaxAge == 0 {
+		r.MaxAge = def.MaxAge
+	}
+	return r
+}
+
+// Attempt is the outcome of one attempt to forward a delivery to a target
+type Attempt struct {
+	Target string
+	Time   time.Time
+	// StatusCode is 0 if the target could not be reached
+	StatusCode int
+	Err        string
+	// Location is the queue item of the build triggered on Jenkins
+	Location string
+}
+
+// retryable tells whether the outcome is worth retrying, only
+// transport errors and transient statuses are. Errors building the
+// request or waking the target fail the same way on every attempt.
+func retryable(o *Outcome) bool {
+	if o.Err != nil {
+		return transient(o.Err)
+	}
+	switch o.Response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// transient tells whether the error is a transport error, or the
+// limits of the target not available in time
+func transient(err error) bool {
+	switch err.(type) {
+	case *url.Error, net.Error:
+		return true
+	}
+	return err == ErrTargetBusy || err == io.ErrUnexpectedEOF
+}
+
+// delay returns how long to wait before the next attempt, the
+// Retry-After of the response if any, otherwise an exponential
+// backoff with jitter
+func (s *service) delay(o *Outcome, attempt int) time.Duration {
+	if o.Response != nil {
+		if d, ok := retryAfter(o.Response.Header.Get("Retry-After")); ok {
+			return d
+		}
+	}
+	d := s.backoff
+	for i := 1; i < attempt && d < s.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.maxBackoff {
+		d = s.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// wait between half and the full backoff
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter parses a Retry-After header in seconds or as HTTP date
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := time.Until(t); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
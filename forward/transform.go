@@ -0,0 +1,119 @@
+package forward
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"text/template"
+
+	"github.com/fabric8-services/fabric8-webhook/jsonpath"
+)
+
+// Transform renders the outbound body instead of passing the payload
+// of the delivery through, with either a template or JSONPath projections
+type Transform struct {
+	// Body is a template of HeaderData e.g.
+	// {"sha": {{json .Commit}}, "repo": {{jsonpath "$.repository.full_name" .Payload | json}}}
+	Body string `mapstructure:"body"`
+	// Projection maps the members of the outbound JSON object
+	// to JSONPath expressions on the payload e.g. sha: $.after
+	Projection map[string]string `mapstructure:"projection"`
+	// ContentType of the outbound body, application/json if empty
+	ContentType string `mapstructure:"content_type"`
+}
+
+// enabled tells whether the body is transformed
+func (tr Transform) enabled() bool {
+	return tr.Body != "" || len(tr.Projection) > 0
+}
+
+// Validate checks the template renders the sample
+// payload and the JSONPath expressions compile
+func (tr Transform) Validate() error {
+	if tr.Body != "" && len(tr.Projection) > 0 {
+		return errors.New("transform has either a body or a projection")
+	}
+	_, err := tr.render(sampleData())
+	return err
+}
+
+// render returns the outbound body
+func (tr Transform) render(data *HeaderData) ([]byte, error) {
+	if tr.Body != "" {
+		body, err := execTemplate(tr.Body, data)
+		return []byte(body), err
+	}
+	doc := map[string]interface{}{}
+	for name, expr := range tr.Projection {
+		p, err := jsonpath.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		v, err := p.Lookup(data.Payload())
+		if err != nil && err != jsonpath.ErrNotFound {
+			return nil, err
+		}
+		doc[name] = v
+	}
+	return json.Marshal(doc)
+}
+
+func (tr Transform) contentType() string {
+	if tr.ContentType == "" {
+		return "application/json"
+	}
+	return tr.ContentType
+}
+
+// templateFuncs are the functions of the header and body templates
+var templateFuncs = template.FuncMap{
+	// json encodes the value e.g. {{json .Branch}}
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// jsonpath looks up the expression in the document,
+	// nil if the path does not exist
+	"jsonpath": func(expr string, doc interface{}) (interface{}, error) {
+		p, err := jsonpath.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		v, err := p.Lookup(doc)
+		if err == jsonpath.ErrNotFound {
+			return nil, nil
+		}
+		return v, err
+	},
+}
+
+// samplePush is the GitHub push rendered to validate the templates
+const samplePush = `{
+  "ref": "refs/heads/master",
+  "before": "28e1879d029cb852e4844d9c718537df08844e03",
+  "after": "bffeb74224043ba2feb48d137756c8a9331c449a",
+  "repository": {
+    "name": "fabric8-webhook",
+    "full_name": "fabric8-services/fabric8-webhook",
+    "git_url": "git://github.com/fabric8-services/fabric8-webhook.git",
+    "clone_url": "https://github.com/fabric8-services/fabric8-webhook.git"
+  },
+  "pusher": {"name": "octocat"}
+}`
+
+// sampleData returns the data rendered to validate the templates
+func sampleData() *HeaderData {
+	d := &Delivery{
+		ID:     "00000000-0000-4000-8000-000000000000",
+		Method: "POST",
+		URL:    &url.URL{Path: "/api/webhook"},
+		Header: http.Header{"X-Github-Event": []string{"push"}},
+		Body:   []byte(samplePush),
+	}
+	return d.headerData(Target{Event: Event{
+		GitURL: "git://github.com/fabric8-services/fabric8-webhook.git",
+		Ref:    "refs/heads/master",
+		Commit: "bffeb74224043ba2feb48d137756c8a9331c449a",
+	}})
+}
@@ -0,0 +1,214 @@
+package forward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/fabric8-services/fabric8-webhook/util"
+)
+
+// Transport configures the connections to a target, zero values
+// fall back to the default HTTP client
+type Transport struct {
+	// CAFile is a PEM bundle of the CAs trusted for the target
+	CAFile string `mapstructure:"ca_file"`
+	// CertFile and KeyFile are the PEM client certificate and key
+	// presented to the target
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ServerName is sent as SNI and verified against the certificate
+	// of the target instead of the host of its URL
+	ServerName string `mapstructure:"server_name"`
+	// Proxy is the URL of the HTTP proxy to the target, the
+	// proxy of the environment is used if empty
+	Proxy                 string        `mapstructure:"proxy"`
+	DialTimeout           time.Duration `mapstructure:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `mapstructure:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"response_header_timeout"`
+	// Timeout caps the whole forward including reading the response
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Validate checks the files can be loaded and the proxy is an URL
+func (t Transport) Validate() error {
+	if t.DialTimeout < 0 || t.TLSHandshakeTimeout < 0 || t.ResponseHeaderTimeout < 0 || t.Timeout < 0 {
+		return fmt.Errorf("transport timeouts must not be negative")
+	}
+	_, err := t.client(0)
+	return err
+}
+
+// client builds the HTTP client of the transport, keeping up to
+// maxIdleConnsPerHost connections alive to each host
+func (t Transport) client(maxIdleConnsPerHost int) (*http.Client, error) {
+	tlsConfig := &tls.Config{ServerName: t.ServerName}
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in " + t.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, errors.New("cert_file and key_file must be set together")
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	proxy := http.ProxyFromEnvironment
+	if t.Proxy != "" {
+		u, err := url.Parse(t.Proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", t.Proxy)
+		}
+		proxy = http.ProxyURL(u)
+	}
+	dialTimeout := t.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = 30 * time.Second
+	}
+	handshakeTimeout := t.TLSHandshakeTimeout
+	if handshakeTimeout == 0 {
+		handshakeTimeout = 10 * time.Second
+	}
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = util.NetClient.Timeout
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   dialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   handshakeTimeout,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			ResponseHeaderTimeout: t.ResponseHeaderTimeout,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}, nil
+}
+
+// endpoint is the long-lived client of a target with its parsed URL
+type endpoint struct {
+	url    *url.URL
+	client *http.Client
+}
+
+type endpointKey struct {
+	url       string
+	transport Transport
+}
+
+// pool holds the endpoint of every target, so forwards neither parse
+// the target nor open new connections. Targets with the same transport
+// share their client and its keep-alive connections.
+type pool struct {
+	lock                sync.RWMutex
+	maxIdleConnsPerHost int
+	clients             map[Transport]*http.Client
+	endpoints           map[endpointKey]*endpoint
+	crumbs              *crumbs
+}
+
+func newPool(maxIdleConnsPerHost int) *pool {
+	return &pool{
+		maxIdleConnsPerHost: maxIdleConnsPerHost,
+		clients:             map[Transport]*http.Client{},
+		endpoints:           map[endpointKey]*endpoint{},
+		crumbs:              &crumbs{issued: map[string]*crumb{}},
+	}
+}
+
+// get returns the endpoint of the target, built on first use
+// if the target was not prepared
+func (p *pool) get(t Target) (*endpoint, error) {
+	key := endpointKey{url: t.URL, transport: t.Transport}
+	p.lock.RLock()
+	e, ok := p.endpoints[key]
+	p.lock.RUnlock()
+	if ok {
+		return e, nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if e, ok := p.endpoints[key]; ok {
+		return e, nil
+	}
+	e, err := p.build(t, p.clients)
+	if err != nil {
+		return nil, err
+	}
+	p.endpoints[key] = e
+	return e, nil
+}
+
+// prepare builds the endpoints of the targets and drops the others,
+// closing the idle connections of the clients no longer used
+func (p *pool) prepare(targets []Target) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	clients := map[Transport]*http.Client{}
+	if c, ok := p.clients[Transport{}]; ok {
+		// kept for the targets resolved outside the routes
+		clients[Transport{}] = c
+	}
+	endpoints := map[endpointKey]*endpoint{}
+	for _, t := range targets {
+		e, err := p.build(t, clients)
+		if err != nil {
+			return fmt.Errorf("target %s: %v", t.URL, err)
+		}
+		endpoints[endpointKey{url: t.URL, transport: t.Transport}] = e
+	}
+	for transport, c := range p.clients {
+		if _, ok := clients[transport]; !ok {
+			if tr, ok := c.Transport.(*http.Transport); ok {
+				tr.CloseIdleConnections()
+			}
+		}
+	}
+	p.clients = clients
+	p.endpoints = endpoints
+	return nil
+}
+
+// build returns the endpoint of the target, reusing the client
+// of its transport from clients or adding it there
+func (p *pool) build(t Target, clients map[Transport]*http.Client) (*endpoint, error) {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := clients[t.Transport]
+	if !ok {
+		if c, ok = p.clients[t.Transport]; !ok {
+			if c, err = t.Transport.client(p.maxIdleConnsPerHost); err != nil {
+				return nil, err
+			}
+		}
+		clients[t.Transport] = c
+	}
+	return &endpoint{url: u, client: c}, nil
+}
@@ -0,0 +1,145 @@
+package forward
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Event describes the change carried by a delivery,
+// for the templates of triggers and headers
+type Event struct {
+	// Type is the normalized event type e.g. push or pull_request
+	Type   string
+	GitURL string
+	// Ref is the fully qualified ref e.g. refs/heads/master
+	Ref    string
+	Commit string
+	// PullRequest is the number of the pull request, 0 if none
+	PullRequest int
+}
+
+// Trigger calls the Jenkins REST API of the target instead of
+// forwarding the payload: it schedules a scan of a multibranch
+// project, or a build of a job with parameters. The target URL
+// is the root of Jenkins.
+type Trigger struct {
+	// Job is the template of the job, folders separated by /
+	// e.g. {{.Name}}/{{.Branch}}. Triggering is disabled if empty.
+	Job string `mapstructure:"job"`
+	// Scan schedules the branch indexing of the multibranch project Job
+	Scan bool `mapstructure:"scan"`
+	// Parameters of the build, their values are templates
+	// e.g. {{.Commit}}
+	Parameters map[string]string `mapstructure:"parameters"`
+}
+
+// enabled tells whether the target is triggered
+func (tr Trigger) enabled() bool {
+	return tr.Job != ""
+}
+
+// Validate checks the templates execute and the trigger
+// either scans or builds with parameters
+func (tr Trigger) Validate() error {
+	if !tr.enabled() {
+		if tr.Scan || len(tr.Parameters) > 0 {
+			return fmt.Errorf("trigger requires job")
+		}
+		return nil
+	}
+	if tr.Scan && len(tr.Parameters) > 0 {
+		return fmt.Errorf("trigger scans have no parameters")
+	}
+	_, err := tr.url(&url.URL{Scheme: "http", Host: "jenkins"}, sampleData())
+	return err
+}
+
+// url returns the URL triggering the job of jenkins. Each folder of
+// the job template is rendered and escaped on its own, so rendered
+// values like branches with / stay in their folder. The query of
+// jenkins e.g. an authentication token is kept.
+func (tr Trigger) url(jenkins *url.URL, data *HeaderData) (string, error) {
+	u := *jenkins
+	p := strings.TrimSuffix(u.Path, "/")
+	raw := strings.TrimSuffix(u.EscapedPath(), "/")
+	for _, folder := range strings.Split(strings.Trim(tr.Job, "/"), "/") {
+		name, err := execTemplate(folder, data)
+		if err != nil {
+			return "", fmt.Errorf("job: %v", err)
+		}
+		if name == "" {
+			return "", fmt.Errorf("job: %q renders an empty folder", folder)
+		}
+		p += "/job/" + name
+		raw += "/job/" + url.PathEscape(name)
+	}
+	q := u.Query()
+	switch {
+	case tr.Scan:
+		p += "/build"
+		raw += "/build"
+		q.Set("delay", "0")
+	case len(tr.Parameters) > 0:
+		p += "/buildWithParameters"
+		raw += "/buildWithParameters"
+		for name, value := range tr.Parameters {
+			v, err := execTemplate(value, data)
+			if err != nil {
+				return "", fmt.Errorf("parameter %s: %v", name, err)
+			}
+			q.Set(name, v)
+		}
+	default:
+		p += "/build"
+		raw += "/build"
+	}
+	u.Path = p
+	u.RawPath = raw
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// trigger builds the request triggering the job of the target
+func (d *Delivery) trigger(jenkins *url.URL, t Target) (*http.Request, error) {
+	u, err := t.Trigger.url(jenkins, d.headerData(t))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	if err := t.Headers.apply(req, d.headerData(t)); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// branch returns the branch or tag name of the ref
+func (e Event) branch() string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		if strings.HasPrefix(e.Ref, prefix) {
+			return strings.TrimPrefix(e.Ref, prefix)
+		}
+	}
+	return e.Ref
+}
+
+// name returns the name of the repository
+func (e Event) name() string {
+	return strings.TrimSuffix(path.Base(strings.TrimSuffix(e.GitURL, "/")), ".git")
+}
+
+func (e Event) pullRequest() string {
+	if e.PullRequest == 0 {
+		return ""
+	}
+	return strconv.Itoa(e.PullRequest)
+}
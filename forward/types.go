@@ -0,0 +1,114 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TargetType defines how deliveries are sent to a target
+type TargetType string
+
+const (
+	// TypeJenkins proxies deliveries to the Jenkins GitHub plugin,
+	// or triggers jobs through the Jenkins REST API
+	TypeJenkins TargetType = "jenkins"
+	// TypeTekton posts deliveries to a Tekton EventListener, with
+	// the headers of the sender for the interceptors
+	TypeTekton TargetType = "tekton"
+	// TypeBuildConfig calls the webhook of an OpenShift BuildConfig,
+	// the target URL is .../buildconfigs/<name>/webhooks
+	TypeBuildConfig TargetType = "buildconfig"
+)
+
+// Validate checks the type is known, empty means TypeJenkins
+func (tt TargetType) Validate() error {
+	switch tt {
+	case "", TypeJenkins, TypeTekton, TypeBuildConfig:
+		return nil
+	}
+	return fmt.Errorf("unknown target type %q", tt)
+}
+
+// BuildConfig configures the webhook of OpenShift BuildConfig targets
+type BuildConfig struct {
+	// Secret is the secret of the webhook trigger of the BuildConfig,
+	// File is a mounted secret holding it. One of them is required.
+	Secret string `mapstructure:"secret"`
+	File   string `mapstructure:"file"`
+	// Generic calls the generic webhook with the event of the
+	// delivery instead of passing the GitHub delivery through
+	Generic bool `mapstructure:"generic"`
+}
+
+// Validate checks a single secret is set
+func (bc BuildConfig) Validate() error {
+	if (bc.Secret == "") == (bc.File == "") {
+		return errors.New("buildconfig requires one of secret and file")
+	}
+	return nil
+}
+
+func (bc BuildConfig) secret() (string, error) {
+	if bc.File == "" {
+		return bc.Secret, nil
+	}
+	b, err := ioutil.ReadFile(bc.File)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// tekton builds the request posting the delivery to the EventListener
+// at the target URL, the path of the delivery is not appended
+func (d *Delivery) tekton(listener *url.URL, t Target) (*http.Request, error) {
+	return d.passThrough(listener.String(), t)
+}
+
+// genericBuild is the payload of BuildConfig generic webhooks
+type genericBuild struct {
+	Type string `json:"type"`
+	Git  struct {
+		URI    string `json:"uri"`
+		Ref    string `json:"ref"`
+		Commit string `json:"commit,omitempty"`
+	} `json:"git"`
+}
+
+// buildConfig builds the request calling the webhook of the BuildConfig
+func (d *Delivery) buildConfig(webhooks *url.URL, t Target) (*http.Request, error) {
+	secret, err := t.BuildConfig.secret()
+	if err != nil {
+		return nil, err
+	}
+	u := *webhooks
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + secret
+	if !t.BuildConfig.Generic {
+		u.Path += "/github"
+		return d.passThrough(u.String(), t)
+	}
+	u.Path += "/generic"
+	b := genericBuild{Type: "Git"}
+	b.Git.URI = t.Event.GitURL
+	b.Git.Ref = t.Event.branch()
+	b.Git.Commit = t.Event.Commit
+	body, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := t.Headers.apply(req, d.headerData(t)); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
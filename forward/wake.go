@@ -0,0 +1,218 @@
+package forward
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/fabric8-services/fabric8-webhook/jsonpath"
+)
+
+// ErrWakeTimeout is the error of targets not ready before the wake deadline
+var ErrWakeTimeout = errors.New("Target not ready before the wake deadline")
+
+// Wake wakes idled targets e.g. the Jenkins of OpenShift.io tenants,
+// scaled to zero when unused. Targets responding 503 are idled.
+// The URLs are templates of HeaderData e.g. {{.Tenant}}.
+type Wake struct {
+	// UnidleURL is POSTed to wake the target, waking is disabled if empty
+	UnidleURL string `mapstructure:"unidle_url"`
+	// StatusURL is the idler API telling whether the target is idled,
+	// checked before forwarding
+	StatusURL string `mapstructure:"status_url"`
+	// IdledPath is the JSONPath of the idled flag in the
+	// status, $.data.is_idle if empty
+	IdledPath string `mapstructure:"idled_path"`
+	// ReadyURL is polled until it responds below 500,
+	// the root of the target's host if empty
+	ReadyURL string `mapstructure:"ready_url"`
+	// Timeout is how long to wait for the target to be ready and
+	// Interval how often it is polled, fall back to the configured defaults
+	Timeout  time.Duration `mapstructure:"timeout"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+const defaultIdledPath = "$.data.is_idle"
+
+// enabled tells whether idled targets are woken
+func (w Wake) enabled() bool {
+	return w.UnidleURL != ""
+}
+
+// Validate checks the templates of the URLs and the JSONPath
+func (w Wake) Validate() error {
+	if w.Timeout < 0 || w.Interval < 0 {
+		return fmt.Errorf("wake timeout and interval must not be negative")
+	}
+	if !w.enabled() && (w.StatusURL != "" || w.ReadyURL != "") {
+		return fmt.Errorf("wake requires unidle_url")
+	}
+	data := sampleData()
+	for _, u := range []string{w.UnidleURL, w.StatusURL, w.ReadyURL} {
+		if _, err := execTemplate(u, data); err != nil {
+			return err
+		}
+	}
+	_, err := jsonpath.Compile(w.idledPath())
+	return err
+}
+
+func (w Wake) idledPath() string {
+	if w.IdledPath == "" {
+		return defaultIdledPath
+	}
+	return w.IdledPath
+}
+
+// waking is a wake-up in progress, shared by the forwards to the target
+type waking struct {
+	done chan struct{}
+	err  error
+}
+
+// wakers wakes targets once for all their forwards
+type wakers struct {
+	lock     sync.Mutex
+	timeout  time.Duration
+	interval time.Duration
+	inFlight map[string]*waking
+}
+
+// idled tells whether the idler status API reports the target
+// idled, targets without status API are reported not idled
+func (ws *wakers) idled(ctx context.Context, client *http.Client, t Target, data *HeaderData) (bool, error) {
+	if t.Wake.StatusURL == "" {
+		return false, nil
+	}
+	u, err := execTemplate(t.Wake.StatusURL, data)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("idler status responded with %d", res.StatusCode)
+	}
+	var doc interface{}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return false, err
+	}
+	p, err := jsonpath.Compile(t.Wake.idledPath())
+	if err != nil {
+		return false, err
+	}
+	v, err := p.Lookup(doc)
+	if err != nil {
+		return false, err
+	}
+	idled, _ := v.(bool)
+	return idled, nil
+}
+
+// wake unidles the target and waits until it is ready, or until ctx is
+// done while the target keeps waking. Concurrent forwards to the same
+// target wait for the same wake-up.
+func (ws *wakers) wake(ctx context.Context, e *endpoint, t Target, data *HeaderData) error {
+	unidle, err := execTemplate(t.Wake.UnidleURL, data)
+	if err != nil {
+		return err
+	}
+	ws.lock.Lock()
+	w, ok := ws.inFlight[unidle]
+	if !ok {
+		w = &waking{done: make(chan struct{})}
+		ws.inFlight[unidle] = w
+		go func() {
+			w.err = ws.unidle(e, t, unidle, data)
+			ws.lock.Lock()
+			delete(ws.inFlight, unidle)
+			ws.lock.Unlock()
+			close(w.done)
+		}()
+	}
+	ws.lock.Unlock()
+	select {
+	case <-w.done:
+		return w.err
+	case <-ctx.Done():
+		return ErrWakeTimeout
+	}
+}
+
+// unidle calls the unidle endpoint then polls the readiness
+// of the target until the deadline
+func (ws *wakers) unidle(e *endpoint, t Target, unidle string, data *HeaderData) error {
+	start := time.Now()
+	err := ws.poll(e, t, unidle, data)
+	result := "success"
+	if err != nil {
+		result = "failure"
+		log.Error(nil, map[string]interface{}{
+			"target": t.URL,
+			"err":    err,
+		}, "failed to wake the target")
+	} else {
+		wakeDuration.Observe(time.Since(start).Seconds())
+		log.Info(nil, map[string]interface{}{
+			"target":   t.URL,
+			"duration": time.Since(start).String(),
+		}, "target woken")
+	}
+	wakeups.WithLabelValues(result).Inc()
+	return err
+}
+
+func (ws *wakers) poll(e *endpoint, t Target, unidle string, data *HeaderData) error {
+	timeout, interval := t.Wake.Timeout, t.Wake.Interval
+	if timeout == 0 {
+		timeout = ws.timeout
+	}
+	if interval == 0 {
+		interval = ws.interval
+	}
+	deadline := time.Now().Add(timeout)
+
+	res, err := e.client.Post(unidle, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unidle responded with %d", res.StatusCode)
+	}
+
+	ready := (&url.URL{Scheme: e.url.Scheme, Host: e.url.Host, Path: "/"}).String()
+	if t.Wake.ReadyURL != "" {
+		if ready, err = execTemplate(t.Wake.ReadyURL, data); err != nil {
+			return err
+		}
+	}
+	for time.Now().Add(interval).Before(deadline) {
+		time.Sleep(interval)
+		res, err := e.client.Get(ready)
+		if err != nil {
+			continue
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode < 500 {
+			return nil
+		}
+	}
+	return ErrWakeTimeout
+}
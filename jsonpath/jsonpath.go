@@ -0,0 +1,112 @@
+// Package jsonpath implements the subset of JSONPath needed to pick
+// single values out of webhook payloads: the root `$`, child access
+// by `.name`, `['name']` or `["name"]` and array indexes `[0]`, `[-1]`.
+package jsonpath
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned when the path doesn't exist in the document
+var ErrNotFound = errors.New("Path not found")
+
+// step is either a member name or an array index
+type step struct {
+	name  string
+	index int
+	isIdx bool
+}
+
+// Path is a compiled JSONPath expression
+type Path struct {
+	expr  string
+	steps []step
+}
+
+// Compile parses a JSONPath expression
+func Compile(expr string) (*Path, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("Invalid JSONPath %q: must start with $", expr)
+	}
+	p := &Path{expr: expr}
+	rest := expr[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" {
+				return nil, fmt.Errorf("Invalid JSONPath %q: empty member name", expr)
+			}
+			p.steps = append(p.steps, step{name: name})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("Invalid JSONPath %q: unterminated [", expr)
+			}
+			s, err := parseBracket(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid JSONPath %q: %v", expr, err)
+			}
+			p.steps = append(p.steps, s)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("Invalid JSONPath %q: unexpected %q", expr, rest[0])
+		}
+	}
+	return p, nil
+}
+
+func parseBracket(s string) (step, error) {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return step{name: s[1 : len(s)-1]}, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return step{}, fmt.Errorf("invalid index %q", s)
+	}
+	return step{index: i, isIdx: true}, nil
+}
+
+// String returns the source expression
+func (p *Path) String() string {
+	return p.expr
+}
+
+// Lookup returns the value at the path in a document
+// decoded by encoding/json into an interface{}
+func (p *Path) Lookup(doc interface{}) (interface{}, error) {
+	v := doc
+	for _, s := range p.steps {
+		if s.isIdx {
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil, ErrNotFound
+			}
+			i := s.index
+			if i < 0 {
+				i += len(a)
+			}
+			if i < 0 || i >= len(a) {
+				return nil, ErrNotFound
+			}
+			v = a[i]
+			continue
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, ErrNotFound
+		}
+		if v, ok = m[s.name]; !ok {
+			return nil, ErrNotFound
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,90 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    []step
+		wantErr bool
+	}{
+		{name: "Root", expr: "$", want: nil},
+		{
+			name: "Dot Notation",
+			expr: "$.repository.clone_url",
+			want: []step{{name: "repository"}, {name: "clone_url"}},
+		},
+		{
+			name: "Bracket Notation",
+			expr: `$['project']["web url"][0]`,
+			want: []step{{name: "project"}, {name: "web url"}, {index: 0, isIdx: true}},
+		},
+		{
+			name: "Negative Index",
+			expr: "$.commits[-1].id",
+			want: []step{{name: "commits"}, {index: -1, isIdx: true}, {name: "id"}},
+		},
+		{name: "Missing Root", expr: "repository", wantErr: true},
+		{name: "Empty Member", expr: "$..name", wantErr: true},
+		{name: "Unterminated Bracket", expr: "$.commits[0", wantErr: true},
+		{name: "Invalid Index", expr: "$.commits[*]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Compile(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Compile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !reflect.DeepEqual(got.steps, tt.want) {
+				t.Errorf("Compile() = %v, want %v", got.steps, tt.want)
+			}
+		})
+	}
+}
+
+func TestPath_Lookup(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(`{
+  "ref": "refs/heads/master",
+  "project": {"git_http_url": "https://gitlab.example.com/org/repo.git"},
+  "commits": [{"id": "a"}, {"id": "b"}]
+}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		expr    string
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "Member", expr: "$.ref", want: "refs/heads/master"},
+		{name: "Nested Member", expr: "$.project.git_http_url", want: "https://gitlab.example.com/org/repo.git"},
+		{name: "Index", expr: "$.commits[0].id", want: "a"},
+		{name: "Negative Index", expr: "$.commits[-1].id", want: "b"},
+		{name: "Missing Member", expr: "$.project.ssh_url", wantErr: true},
+		{name: "Index Out Of Range", expr: "$.commits[2]", wantErr: true},
+		{name: "Index On Object", expr: "$.project[0]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Compile(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Lookup(doc)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Path.Lookup() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Path.Lookup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
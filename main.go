@@ -14,6 +14,16 @@ import (
 	"github.com/fabric8-services/fabric8-webhook/build"
 	"github.com/fabric8-services/fabric8-webhook/configuration"
 	"github.com/fabric8-services/fabric8-webhook/controller"
+	"github.com/fabric8-services/fabric8-webhook/deadletter"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/osd"
+	"github.com/fabric8-services/fabric8-webhook/provider"
+	"github.com/fabric8-services/fabric8-webhook/registry"
+	"github.com/fabric8-services/fabric8-webhook/resolver"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+	"github.com/fabric8-services/fabric8-webhook/storage"
+	"github.com/fabric8-services/fabric8-webhook/storage/bolt"
+	"github.com/fabric8-services/fabric8-webhook/storage/postgres"
 	"github.com/fabric8-services/fabric8-webhook/verification"
 	"github.com/goadesign/goa"
 	goalogrus "github.com/goadesign/goa/logging/logrus"
@@ -97,27 +107,144 @@ func main() {
 
 	// service.Use(metric.Recorder())
 
-	// Mount the 'status controller
-	statusCtrl := controller.NewStatusController(service)
-	app.MountStatusController(service, statusCtrl)
+	ghInstances, err := config.GetGitHubInstances()
+	if err != nil {
+		log.Panic(nil, map[string]interface{}{
+			"err": err,
+		}, "failed to read the GitHub instances")
+	}
 
-	verificationSvc, err := verification.New(service, config.GetMonitorIPDuration())
+	verificationSvc, err := verification.New(service,
+		config.GetMonitorIPDuration(), ghInstances)
 	if err != nil {
 		log.Panic(nil, map[string]interface{}{
 			"err": err,
 		}, "failed to setup the verification service")
 	}
 
-	buildSvc := build.New()
+	providerSvc, err := provider.New(config, verificationSvc)
+	if err != nil {
+		log.Panic(nil, map[string]interface{}{
+			"err": err,
+		}, "failed to setup the provider service")
+	}
+
+	buildSvc, err := build.New(config)
+	if err != nil {
+		log.Panic(nil, map[string]interface{}{
+			"err": err,
+		}, "failed to setup the build service")
+	}
+
+	// Without storage backend deliveries are neither
+	// recorded nor durably queued
+	var store storage.Store
+	var queue forward.Queue
+	switch config.GetStorageBackend() {
+	case "":
+	case "postgres":
+		store, err = postgres.New(config)
+		if err != nil {
+			log.Panic(nil, map[string]interface{}{
+				"err": err,
+			}, "failed to setup the postgres storage")
+		}
+		defer store.Close()
+		queue = store
+	case "bolt":
+		store, err = bolt.New(config)
+		if err != nil {
+			log.Panic(nil, map[string]interface{}{
+				"path": config.GetStorageBoltPath(),
+				"err":  err,
+			}, "failed to setup the embedded storage")
+		}
+		defer store.Close()
+		queue = store
+	default:
+		log.Panic(nil, map[string]interface{}{
+			"backend": config.GetStorageBackend(),
+		}, "unknown storage backend")
+	}
+
+	// Without routing file every delivery goes to the proxy URL
+	routingSource := routing.NewStaticSource(
+		&routing.Table{Default: config.GetProxyURL()})
+	if config.GetRoutingFile() != "" {
+		routingSource = routing.NewFileSource(config.GetRoutingFile())
+	}
+	if config.IsRoutingStorage() {
+		if store == nil {
+			log.Panic(nil, map[string]interface{}{}, "routing storage requires a storage backend")
+		}
+		routingSource = routing.NewStoreSource(store, routingSource)
+	}
+	routingSvc, err := routing.New(routingSource,
+		config.GetRoutingReloadDuration())
+	if err != nil {
+		log.Panic(nil, map[string]interface{}{
+			"routing_file": config.GetRoutingFile(),
+			"err":          err,
+		}, "failed to setup the routing service")
+	}
 
 	if err != nil {
 		log.Logger().Fatal("Verification Service Initialisation Failed", err)
 	}
 
+	registrySvc, err := registry.New(config, routingSvc)
+	if err != nil {
+		log.Panic(nil, map[string]interface{}{
+			"err": err,
+		}, "failed to setup the registry service")
+	}
+
+	osdSvc, err := osd.New(config)
+	if err != nil {
+		log.Panic(nil, map[string]interface{}{
+			"err": err,
+		}, "failed to setup the OSD service")
+	}
+
+	resolverSvc := resolver.New(buildSvc, routingSvc, osdSvc)
+
+	deadLetters := deadletter.NewMemoryStore(config.GetDeadLetterSize())
+	if store != nil {
+		deadLetters = store.DeadLetters()
+	}
+	forwardSvc := forward.New(config, deadLetters, queue, resolverSvc)
+	routingSvc.OnReload(func(t *routing.Table) {
+		if err := forwardSvc.Prepare(t.Targets()); err != nil {
+			log.Error(nil, map[string]interface{}{
+				"err": err,
+			}, "failed to prepare the forward targets")
+		}
+	})
+
+	// Mount the 'status controller
+	statusCtrl := controller.NewStatusController(service, forwardSvc)
+	app.MountStatusController(service, statusCtrl)
+
 	// Mount "webhook" controller
 	webhookCtrl := controller.NewWebhookController(service,
-		config, verificationSvc, buildSvc)
+		providerSvc, registrySvc, resolverSvc,
+		forwardSvc, store, config.GetAdminToken())
 	app.MountWebhookController(service, webhookCtrl)
+
+	// Mount "routing" controller
+	var routingStore routing.TableStore
+	if config.IsRoutingStorage() {
+		routingStore = store
+	}
+	routingCtrl := controller.NewRoutingController(service,
+		routingSvc, routingStore, config.GetAdminToken())
+	app.MountRoutingController(service, routingCtrl)
+
+	// Mount "deadletter" controller
+	deadletterCtrl := controller.NewDeadletterController(service,
+		deadLetters, forwardSvc, resolverSvc, providerSvc.SecretHeaders(),
+		config.GetAdminToken())
+	app.MountDeadletterController(service, deadletterCtrl)
 	log.Logger().Infoln("Git Commit SHA: ", app.Commit)
 	log.Logger().Infoln("UTC Build Time: ", app.BuildTime)
 	log.Logger().Infoln("UTC Start Time: ", app.StartTime)
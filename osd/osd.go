@@ -0,0 +1,77 @@
+package osd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-webhook/configuration"
+)
+
+// ErrTenantNotConfigured is returned for OSD tenants
+// without a configured cluster
+var ErrTenantNotConfigured = errors.New("OSD tenant not configured")
+
+// Endpoint is the Jenkins of a tenant on its cluster
+type Endpoint struct {
+	URL string
+	// Token is the bearer token to authenticate on the cluster
+	Token string
+}
+
+// Service defines resolution of Jenkins endpoints of
+// tenants on OpenShift Dedicated clusters
+type Service interface {
+	Resolve(tenant string) (*Endpoint, error)
+}
+
+// serviceConfiguration the Configuration needed by the OSD service
+type serviceConfiguration interface {
+	GetOSDClusters() (map[string]configuration.OSDCluster, error)
+	GetOSDTenants() (map[string]configuration.OSDTenant, error)
+}
+
+type service struct {
+	clusters map[string]configuration.OSDCluster
+	tenants  map[string]configuration.OSDTenant
+}
+
+// New returns an OSD service instance
+func New(config serviceConfiguration) (Service, error) {
+	clusters, err := config.GetOSDClusters()
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := config.GetOSDTenants()
+	if err != nil {
+		return nil, err
+	}
+	for name, c := range clusters {
+		if c.JenkinsURL == "" {
+			return nil, fmt.Errorf("OSD cluster %s: jenkins_url is required", name)
+		}
+	}
+	for name, t := range tenants {
+		if _, ok := clusters[t.Cluster]; !ok {
+			return nil, fmt.Errorf("OSD tenant %s: unknown cluster %q", name, t.Cluster)
+		}
+	}
+	return &service{clusters: clusters, tenants: tenants}, nil
+}
+
+// Resolve returns the Jenkins endpoint of the tenant on its cluster
+func (s *service) Resolve(tenant string) (*Endpoint, error) {
+	t, ok := s.tenants[strings.ToLower(tenant)]
+	if !ok {
+		return nil, ErrTenantNotConfigured
+	}
+	c := s.clusters[t.Cluster]
+	token := t.Token
+	if token == "" {
+		token = c.Token
+	}
+	return &Endpoint{
+		URL:   strings.Replace(c.JenkinsURL, "{tenant}", strings.ToLower(tenant), -1),
+		Token: token,
+	}, nil
+}
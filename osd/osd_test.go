@@ -0,0 +1,113 @@
+package osd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-webhook/configuration"
+)
+
+type config struct {
+	clusters map[string]configuration.OSDCluster
+	tenants  map[string]configuration.OSDTenant
+}
+
+func (c *config) GetOSDClusters() (map[string]configuration.OSDCluster, error) {
+	return c.clusters, nil
+}
+
+func (c *config) GetOSDTenants() (map[string]configuration.OSDTenant, error) {
+	return c.tenants, nil
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *config
+		wantErr bool
+	}{
+		{
+			name: "Valid Configuration",
+			config: &config{
+				clusters: map[string]configuration.OSDCluster{"c1": {JenkinsURL: "https://jenkins"}},
+				tenants:  map[string]configuration.OSDTenant{"alice": {Cluster: "c1"}},
+			},
+		},
+		{
+			name: "Cluster Without Jenkins URL",
+			config: &config{
+				clusters: map[string]configuration.OSDCluster{"c1": {}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Tenant On Unknown Cluster",
+			config: &config{
+				clusters: map[string]configuration.OSDCluster{"c1": {JenkinsURL: "https://jenkins"}},
+				tenants:  map[string]configuration.OSDTenant{"alice": {Cluster: "c2"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_service_Resolve(t *testing.T) {
+	s, err := New(&config{
+		clusters: map[string]configuration.OSDCluster{
+			"c1": {JenkinsURL: "https://jenkins-{tenant}.apps.c1.example.com", Token: "cluster-token"},
+		},
+		tenants: map[string]configuration.OSDTenant{
+			"alice": {Cluster: "c1"},
+			"bob":   {Cluster: "c1", Token: "bob-token"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		tenant  string
+		want    *Endpoint
+		wantErr error
+	}{
+		{
+			name:   "Cluster Token",
+			tenant: "alice",
+			want:   &Endpoint{URL: "https://jenkins-alice.apps.c1.example.com", Token: "cluster-token"},
+		},
+		{
+			name:   "Tenant Token",
+			tenant: "bob",
+			want:   &Endpoint{URL: "https://jenkins-bob.apps.c1.example.com", Token: "bob-token"},
+		},
+		{
+			name:    "Unknown Tenant",
+			tenant:  "carol",
+			wantErr: ErrTenantNotConfigured,
+		},
+		{
+			name:    "Unknown Tenant Empty",
+			tenant:  "",
+			wantErr: ErrTenantNotConfigured,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Resolve(tt.tenant)
+			if err != tt.wantErr {
+				t.Errorf("service.Resolve() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("service.Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
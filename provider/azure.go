@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// azureHookStruct a simplified structure to get info from
+// an Azure DevOps service hook request
+type azureHookStruct struct {
+	EventType string `json:"eventType"`
+	Resource  struct {
+		RefUpdates []struct {
+			Name        string `json:"name"`
+			NewObjectID string `json:"newObjectId"`
+		} `json:"refUpdates"`
+		PullRequestID         int    `json:"pullRequestId"`
+		SourceRefName         string `json:"sourceRefName"`
+		LastMergeSourceCommit struct {
+			CommitID string `json:"commitId"`
+		} `json:"lastMergeSourceCommit"`
+		Repository struct {
+			Name      string `json:"name"`
+			RemoteURL string `json:"remoteUrl"`
+		} `json:"repository"`
+	} `json:"resource"`
+}
+
+// azure handles Azure DevOps service hooks, which authenticate
+// with basic-auth credentials instead of a signature
+type azure struct {
+	username string
+	password string
+}
+
+func (*azure) Name() string {
+	return "azure"
+}
+
+// Match checks the User-Agent as service hooks
+// carry no event specific header
+func (*azure) Match(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("User-Agent"), "VSServices/")
+}
+
+// Verify checks the basic-auth credentials of the
+// request against the configured ones
+func (a *azure) Verify(req *http.Request, body []byte) (bool, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok || a.username == "" || a.password == "" {
+		return false, nil
+	}
+	validUser := subtle.ConstantTimeCompare([]byte(username), []byte(a.username)) == 1
+	validPassword := subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) == 1
+	return validUser && validPassword, nil
+}
+
+func (a *azure) Parse(req *http.Request, body []byte) (*Event, error) {
+	az := azureHookStruct{}
+	if err := json.Unmarshal(body, &az); err != nil {
+		return nil, err
+	}
+
+	e := &Event{
+		Provider: a.Name(),
+		GitURL:   az.Resource.Repository.RemoteURL,
+	}
+	switch az.EventType {
+	case "git.push":
+		// A push can update several refs, the first updated one is
+		// used. A push deleting every ref is a delete event.
+		if len(az.Resource.RefUpdates) == 0 {
+			return nil, ErrUnsupportedEvent
+		}
+		e.Type = "delete"
+		e.Ref = az.Resource.RefUpdates[0].Name
+		for _, u := range az.Resource.RefUpdates {
+			if strings.Trim(u.NewObjectID, "0") != "" {
+				e.Type = "push"
+				e.Ref = u.Name
+				e.Commit = u.NewObjectID
+				break
+			}
+		}
+	case "git.pullrequest.created", "git.pullrequest.updated":
+		e.Type = "pull_request"
+		e.PullRequest = az.Resource.PullRequestID
+		e.Ref = az.Resource.SourceRefName
+		e.Commit = az.Resource.LastMergeSourceCommit.CommitID
+	default:
+		return nil, ErrUnsupportedEvent
+	}
+	return e, nil
+}
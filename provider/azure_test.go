@@ -0,0 +1,194 @@
+package provider
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func Test_azure_Verify(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     bool
+	}{
+		{
+			name:     "Verify Basic Auth Positive",
+			username: "jenkins",
+			password: "secret",
+			want:     true,
+		},
+		{
+			name:     "Verify Basic Auth Negative - Wrong Password",
+			username: "jenkins",
+			password: "other",
+			want:     false,
+		},
+		{
+			name:     "Verify Basic Auth Negative - Wrong Username",
+			username: "other",
+			password: "secret",
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{Header: http.Header{}}
+			req.SetBasicAuth(tt.username, tt.password)
+			a := &azure{username: "jenkins", password: "secret"}
+			got, err := a.Verify(req, nil)
+			if err != nil {
+				t.Errorf("azure.Verify() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("azure.Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Verify Basic Auth Negative - Not Configured", func(t *testing.T) {
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth("", "")
+		if got, _ := (&azure{}).Verify(req, nil); got {
+			t.Errorf("azure.Verify() = %v, want %v", got, false)
+		}
+	})
+}
+
+func Test_azure_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    *Event
+		wantErr bool
+	}{
+		{
+			name: "Parse Push",
+			body: `{
+  "eventType": "git.push",
+  "resource": {
+    "refUpdates": [
+      {
+        "name": "refs/heads/master",
+        "oldObjectId": "aad331d8d3b131fa9ae03cf5e53965b51942618a",
+        "newObjectId": "33b55f7cb7e7e245323987634f960cf4a6e6bc74"
+      }
+    ],
+    "repository": {
+      "name": "Fabrikam-Fiber-Git",
+      "remoteUrl": "https://fabrikam.visualstudio.com/DefaultCollection/_git/Fabrikam-Fiber-Git"
+    }
+  }
+}`,
+			want: &Event{
+				Provider: "azure",
+				Type:     "push",
+				GitURL:   "https://fabrikam.visualstudio.com/DefaultCollection/_git/Fabrikam-Fiber-Git",
+				Ref:      "refs/heads/master",
+				Commit:   "33b55f7cb7e7e245323987634f960cf4a6e6bc74",
+			},
+		},
+		{
+			name: "Parse Push Deleting First Ref",
+			body: `{
+  "eventType": "git.push",
+  "resource": {
+    "refUpdates": [
+      {
+        "name": "refs/heads/old",
+        "oldObjectId": "aad331d8d3b131fa9ae03cf5e53965b51942618a",
+        "newObjectId": "0000000000000000000000000000000000000000"
+      },
+      {
+        "name": "refs/heads/master",
+        "oldObjectId": "aad331d8d3b131fa9ae03cf5e53965b51942618a",
+        "newObjectId": "33b55f7cb7e7e245323987634f960cf4a6e6bc74"
+      }
+    ],
+    "repository": {
+      "remoteUrl": "https://fabrikam.visualstudio.com/DefaultCollection/_git/Fabrikam-Fiber-Git"
+    }
+  }
+}`,
+			want: &Event{
+				Provider: "azure",
+				Type:     "push",
+				GitURL:   "https://fabrikam.visualstudio.com/DefaultCollection/_git/Fabrikam-Fiber-Git",
+				Ref:      "refs/heads/master",
+				Commit:   "33b55f7cb7e7e245323987634f960cf4a6e6bc74",
+			},
+		},
+		{
+			name: "Parse Push Deleting Ref",
+			body: `{
+  "eventType": "git.push",
+  "resource": {
+    "refUpdates": [
+      {
+        "name": "refs/heads/old",
+        "oldObjectId": "aad331d8d3b131fa9ae03cf5e53965b51942618a",
+        "newObjectId": "0000000000000000000000000000000000000000"
+      }
+    ],
+    "repository": {
+      "remoteUrl": "https://fabrikam.visualstudio.com/DefaultCollection/_git/Fabrikam-Fiber-Git"
+    }
+  }
+}`,
+			want: &Event{
+				Provider: "azure",
+				Type:     "delete",
+				GitURL:   "https://fabrikam.visualstudio.com/DefaultCollection/_git/Fabrikam-Fiber-Git",
+				Ref:      "refs/heads/old",
+			},
+		},
+		{
+			name: "Parse Pull Request Updated",
+			body: `{
+  "eventType": "git.pullrequest.updated",
+  "resource": {
+    "pullRequestId": 1,
+    "sourceRefName": "refs/heads/mytopic",
+    "targetRefName": "refs/heads/master",
+    "lastMergeSourceCommit": {
+      "commitId": "53d54ac915144006c2c9e90d2c7d3880920db49c"
+    },
+    "repository": {
+      "remoteUrl": "https://fabrikam.visualstudio.com/DefaultCollection/_git/Fabrikam-Fiber-Git"
+    }
+  }
+}`,
+			want: &Event{
+				Provider:    "azure",
+				Type:        "pull_request",
+				GitURL:      "https://fabrikam.visualstudio.com/DefaultCollection/_git/Fabrikam-Fiber-Git",
+				Ref:         "refs/heads/mytopic",
+				Commit:      "53d54ac915144006c2c9e90d2c7d3880920db49c",
+				PullRequest: 1,
+			},
+		},
+		{
+			name:    "Parse Push Without Ref Updates",
+			body:    `{"eventType": "git.push", "resource": {}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Parse Unsupported Event",
+			body:    `{"eventType": "workitem.created"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&azure{}).Parse(&http.Request{}, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("azure.Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("azure.Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
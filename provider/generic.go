@@ -0,0 +1,163 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-webhook/configuration"
+	"github.com/fabric8-services/fabric8-webhook/jsonpath"
+)
+
+// ErrUnknownSource is returned for generic deliveries
+// of a source which isn't configured
+var ErrUnknownSource = errors.New("Unknown generic webhook source")
+
+// field is either a JSONPath expression or a literal value
+type field struct {
+	path    *jsonpath.Path
+	literal string
+}
+
+func newField(s string) (field, error) {
+	if !strings.HasPrefix(s, "$") {
+		return field{literal: s}, nil
+	}
+	p, err := jsonpath.Compile(s)
+	if err != nil {
+		return field{}, err
+	}
+	return field{path: p}, nil
+}
+
+// value returns the field of doc, and false when it is missing
+func (f field) value(doc interface{}) (string, bool, error) {
+	if f.path == nil {
+		return f.literal, f.literal != "", nil
+	}
+	v, err := f.path.Lookup(doc)
+	if err == jsonpath.ErrNotFound || v == nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, true, nil
+	case json.Number, bool:
+		return fmt.Sprint(v), true, nil
+	}
+	return "", false, fmt.Errorf("%s is not a scalar value", f.path)
+}
+
+// generic handles deliveries of senders without a dedicated
+// provider by mapping payload fields with JSONPath expressions
+type generic struct {
+	name   string
+	source configuration.GenericSource
+
+	repository field
+	ref        field
+	commit     field
+	event      field
+}
+
+func newGeneric(name string, source configuration.GenericSource) (*generic, error) {
+	if source.Repository == "" {
+		return nil, fmt.Errorf("Generic source %s: repository is required", name)
+	}
+	if source.Secret == "" && source.Token == "" {
+		return nil, fmt.Errorf("Generic source %s: secret or token is required", name)
+	}
+	g := &generic{name: name, source: source}
+	for _, f := range []struct {
+		expr string
+		dst  *field
+	}{
+		{source.Repository, &g.repository},
+		{source.Ref, &g.ref},
+		{source.Commit, &g.commit},
+		{source.Event, &g.event},
+	} {
+		var err error
+		if *f.dst, err = newField(f.expr); err != nil {
+			return nil, fmt.Errorf("Generic source %s: %v", name, err)
+		}
+	}
+	return g, nil
+}
+
+func (g *generic) Name() string {
+	return "generic/" + g.name
+}
+
+// Match never matches as generic deliveries have their own endpoint
+func (*generic) Match(req *http.Request) bool {
+	return false
+}
+
+// Verify checks the HMAC SHA256 signature of the body, optionally
+// prefixed with `sha256=`, or the token, in the configured headers
+func (g *generic) Verify(req *http.Request, body []byte) (bool, error) {
+	if g.source.Secret != "" {
+		sig := strings.TrimPrefix(req.Header.Get(g.signatureHeader()), "sha256=")
+		if sig == "" || !validSignature(g.source.Secret, sig, body) {
+			return false, nil
+		}
+	}
+	if g.source.Token != "" {
+		token := req.Header.Get(g.tokenHeader())
+		if subtle.ConstantTimeCompare([]byte(token), []byte(g.source.Token)) != 1 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (g *generic) signatureHeader() string {
+	if g.source.SignatureHeader != "" {
+		return g.source.SignatureHeader
+	}
+	return "X-Signature"
+}
+
+func (g *generic) tokenHeader() string {
+	if g.source.TokenHeader != "" {
+		return g.source.TokenHeader
+	}
+	return "X-Token"
+}
+
+func (g *generic) Parse(req *http.Request, body []byte) (*Event, error) {
+	var doc interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	e := &Event{Provider: g.Name()}
+	repo, ok, err := g.repository.value(doc)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: repository not found in payload", g.Name())
+	}
+	e.GitURL = repo
+	if e.Ref, _, err = g.ref.value(doc); err != nil {
+		return nil, err
+	}
+	if e.Commit, _, err = g.commit.value(doc); err != nil {
+		return nil, err
+	}
+	if e.Type, _, err = g.event.value(doc); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
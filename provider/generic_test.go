@@ -0,0 +1,158 @@
+package provider
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-webhook/configuration"
+)
+
+func Test_newGeneric(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  configuration.GenericSource
+		wantErr bool
+	}{
+		{
+			name:   "Valid Source",
+			source: configuration.GenericSource{Repository: "$.project.url", Token: "t"},
+		},
+		{
+			name:    "Missing Repository",
+			source:  configuration.GenericSource{Token: "t"},
+			wantErr: true,
+		},
+		{
+			name:    "Missing Secret And Token",
+			source:  configuration.GenericSource{Repository: "$.project.url"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid JSONPath",
+			source:  configuration.GenericSource{Repository: "$.project.url", Ref: "$.ref[", Token: "t"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newGeneric("test", tt.source); (err != nil) != tt.wantErr {
+				t.Errorf("newGeneric() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_generic_Verify(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/master"}`)
+	tests := []struct {
+		name   string
+		source configuration.GenericSource
+		header http.Header
+		want   bool
+	}{
+		{
+			name:   "Verify Signature Positive",
+			source: configuration.GenericSource{Secret: "secret", SignatureHeader: "X-Hook-Signature"},
+			header: http.Header{"X-Hook-Signature": {"sha256=18bd702ca7dab5713101db346ec6cd6768820c090515db9744deff53bc95ff52"}},
+			want:   true,
+		},
+		{
+			name:   "Verify Signature Negative",
+			source: configuration.GenericSource{Secret: "other"},
+			header: http.Header{"X-Signature": {"18bd702ca7dab5713101db346ec6cd6768820c090515db9744deff53bc95ff52"}},
+			want:   false,
+		},
+		{
+			name:   "Verify Token Positive",
+			source: configuration.GenericSource{Token: "s3cr3t"},
+			header: http.Header{"X-Token": {"s3cr3t"}},
+			want:   true,
+		},
+		{
+			name:   "Verify Token Negative",
+			source: configuration.GenericSource{Token: "s3cr3t", TokenHeader: "X-Gitlab-Token"},
+			header: http.Header{"X-Token": {"s3cr3t"}},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &generic{name: "test", source: tt.source}
+			got, err := g.Verify(&http.Request{Header: tt.header}, body)
+			if err != nil {
+				t.Errorf("generic.Verify() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("generic.Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_generic_Parse(t *testing.T) {
+	source := configuration.GenericSource{
+		Repository: "$.project.git_http_url",
+		Ref:        "$.ref",
+		Commit:     "$.checkout_sha",
+		Event:      "push",
+		Token:      "t",
+	}
+	g, err := newGeneric("gitlab", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		body    string
+		want    *Event
+		wantErr bool
+	}{
+		{
+			name: "Parse Mapped Fields",
+			body: `{
+  "ref": "refs/heads/master",
+  "checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "project": {"git_http_url": "https://gitlab.example.com/org/repo.git"}
+}`,
+			want: &Event{
+				Provider: "generic/gitlab",
+				Type:     "push",
+				GitURL:   "https://gitlab.example.com/org/repo.git",
+				Ref:      "refs/heads/master",
+				Commit:   "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+			},
+		},
+		{
+			name: "Parse Missing Optional Fields",
+			body: `{"project": {"git_http_url": "https://gitlab.example.com/org/repo.git"}}`,
+			want: &Event{
+				Provider: "generic/gitlab",
+				Type:     "push",
+				GitURL:   "https://gitlab.example.com/org/repo.git",
+			},
+		},
+		{
+			name:    "Parse Missing Repository",
+			body:    `{"ref": "refs/heads/master"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Parse Non Scalar Field",
+			body:    `{"ref": {"name": "master"}, "project": {"git_http_url": "u"}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.Parse(&http.Request{}, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("generic.Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("generic.Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
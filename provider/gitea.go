@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// giteaHookStruct a simplified structure to get info from
+// a Gitea or Gogs webhook request
+type giteaHookStruct struct {
+	Ref         string `json:"ref"`
+	RefType     string `json:"ref_type"`
+	After       string `json:"after"`
+	SHA         string `json:"sha"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Head struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		CloneURL string `json:"clone_url"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+}
+
+// gitea handles deliveries of Gitea and Gogs, which
+// share the same payload format
+type gitea struct {
+	secret string
+}
+
+func (*gitea) Name() string {
+	return "gitea"
+}
+
+func (*gitea) Match(req *http.Request) bool {
+	return giteaEvent(req) != ""
+}
+
+// Verify checks the HMAC SHA256 signature of the body
+// against the configured secret
+func (g *gitea) Verify(req *http.Request, body []byte) (bool, error) {
+	sig := req.Header.Get("X-Gitea-Signature")
+	if sig == "" {
+		sig = req.Header.Get("X-Gogs-Signature")
+	}
+	if sig == "" || g.secret == "" {
+		return false, nil
+	}
+	return validSignature(g.secret, sig, body), nil
+}
+
+func (g *gitea) Parse(req *http.Request, body []byte) (*Event, error) {
+	gh := giteaHookStruct{}
+	if err := json.Unmarshal(body, &gh); err != nil {
+		return nil, err
+	}
+
+	e := &Event{
+		Provider: g.Name(),
+		Type:     giteaEvent(req),
+		GitURL:   gh.Repository.CloneURL,
+	}
+	switch e.Type {
+	case "push":
+		e.Ref = gh.Ref
+		e.Commit = gh.After
+	case "create", "delete":
+		// create and delete carry the short ref name
+		e.Ref = qualifyRef(gh.RefType, gh.Ref)
+		e.Commit = gh.SHA
+	case "pull_request":
+		e.Ref = qualifyRef("branch", gh.PullRequest.Head.Ref)
+		e.Commit = gh.PullRequest.Head.SHA
+		e.PullRequest = gh.Number
+	default:
+		return nil, ErrUnsupportedEvent
+	}
+	return e, nil
+}
+
+func giteaEvent(req *http.Request) string {
+	if e := req.Header.Get("X-Gitea-Event"); e != "" {
+		return e
+	}
+	return req.Header.Get("X-Gogs-Event")
+}
+
+// qualifyRef turns a short branch or tag name into a fully qualified ref
+func qualifyRef(refType, ref string) string {
+	switch refType {
+	case "tag":
+		return "refs/tags/" + ref
+	case "branch":
+		return "refs/heads/" + ref
+	}
+	return ref
+}
+
+// validSignature compares the hex encoded HMAC SHA256
+// signature of body with sig in constant time
+func validSignature(secret, sig string, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
@@ -0,0 +1,207 @@
+package provider
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func Test_gitea_Verify(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/master"}`)
+	type fields struct {
+		secret string
+	}
+	type args struct {
+		header http.Header
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   bool
+	}{
+		{
+			name:   "Verify Gitea Signature Positive",
+			fields: fields{secret: "secret"},
+			args: args{http.Header{
+				"X-Gitea-Signature": {"18bd702ca7dab5713101db346ec6cd6768820c090515db9744deff53bc95ff52"},
+			}},
+			want: true,
+		},
+		{
+			name:   "Verify Gogs Signature Positive",
+			fields: fields{secret: "secret"},
+			args: args{http.Header{
+				"X-Gogs-Signature": {"18bd702ca7dab5713101db346ec6cd6768820c090515db9744deff53bc95ff52"},
+			}},
+			want: true,
+		},
+		{
+			name:   "Verify Signature Negative - Wrong Secret",
+			fields: fields{secret: "other"},
+			args: args{http.Header{
+				"X-Gitea-Signature": {"18bd702ca7dab5713101db346ec6cd6768820c090515db9744deff53bc95ff52"},
+			}},
+			want: false,
+		},
+		{
+			name:   "Verify Signature Negative - Missing Signature",
+			fields: fields{secret: "secret"},
+			args:   args{http.Header{}},
+			want:   false,
+		},
+		{
+			name:   "Verify Signature Negative - No Secret Configured",
+			fields: fields{secret: ""},
+			args: args{http.Header{
+				"X-Gitea-Signature": {"18bd702ca7dab5713101db346ec6cd6768820c090515db9744deff53bc95ff52"},
+			}},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &gitea{secret: tt.fields.secret}
+			got, err := g.Verify(&http.Request{Header: tt.args.header}, body)
+			if err != nil {
+				t.Errorf("gitea.Verify() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("gitea.Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gitea_Parse(t *testing.T) {
+	type args struct {
+		event string
+		body  string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *Event
+		wantErr bool
+	}{
+		{
+			name: "Parse Push",
+			args: args{
+				event: "push",
+				body: `{
+  "ref": "refs/heads/develop",
+  "before": "28e1879d029cb852e4844d9c718537df08844e03",
+  "after": "bffeb74224043ba2feb48d137756c8a9331c449a",
+  "repository": {
+    "full_name": "gitea/webhooks",
+    "clone_url": "http://localhost:3000/gitea/webhooks.git"
+  }
+}`,
+			},
+			want: &Event{
+				Provider: "gitea",
+				Type:     "push",
+				GitURL:   "http://localhost:3000/gitea/webhooks.git",
+				Ref:      "refs/heads/develop",
+				Commit:   "bffeb74224043ba2feb48d137756c8a9331c449a",
+			},
+		},
+		{
+			name: "Parse Create Tag",
+			args: args{
+				event: "create",
+				body: `{
+  "sha": "bffeb74224043ba2feb48d137756c8a9331c449a",
+  "ref": "v1.0.0",
+  "ref_type": "tag",
+  "repository": {
+    "clone_url": "http://localhost:3000/gitea/webhooks.git"
+  }
+}`,
+			},
+			want: &Event{
+				Provider: "gitea",
+				Type:     "create",
+				GitURL:   "http://localhost:3000/gitea/webhooks.git",
+				Ref:      "refs/tags/v1.0.0",
+				Commit:   "bffeb74224043ba2feb48d137756c8a9331c449a",
+			},
+		},
+		{
+			name: "Parse Delete Branch",
+			args: args{
+				event: "delete",
+				body: `{
+  "ref": "feature",
+  "ref_type": "branch",
+  "repository": {
+    "clone_url": "http://localhost:3000/gitea/webhooks.git"
+  }
+}`,
+			},
+			want: &Event{
+				Provider: "gitea",
+				Type:     "delete",
+				GitURL:   "http://localhost:3000/gitea/webhooks.git",
+				Ref:      "refs/heads/feature",
+			},
+		},
+		{
+			name: "Parse Pull Request",
+			args: args{
+				event: "pull_request",
+				body: `{
+  "action": "opened",
+  "number": 1,
+  "pull_request": {
+    "head": {
+      "ref": "feature",
+      "sha": "bffeb74224043ba2feb48d137756c8a9331c449a"
+    }
+  },
+  "repository": {
+    "clone_url": "http://localhost:3000/gitea/webhooks.git"
+  }
+}`,
+			},
+			want: &Event{
+				Provider:    "gitea",
+				Type:        "pull_request",
+				GitURL:      "http://localhost:3000/gitea/webhooks.git",
+				Ref:         "refs/heads/feature",
+				Commit:      "bffeb74224043ba2feb48d137756c8a9331c449a",
+				PullRequest: 1,
+			},
+		},
+		{
+			name: "Parse Unsupported Event",
+			args: args{
+				event: "issues",
+				body:  `{}`,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Parse Invalid Body",
+			args: args{
+				event: "push",
+				body:  `{"ref": 1}`,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{Header: http.Header{}}
+			req.Header.Set("X-Gitea-Event", tt.args.event)
+			got, err := (&gitea{}).Parse(req, []byte(tt.args.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gitea.Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gitea.Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
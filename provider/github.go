@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/fabric8-services/fabric8-webhook/verification"
+)
+
+// GHHookStruct a simplified structure to get info from
+// a webhook request
+type GHHookStruct struct {
+	Ref         string `json:"ref"`
+	RefType     string `json:"ref_type"`
+	After       string `json:"after"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Head struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Repository struct {
+		Name     string `json:"name"`
+		FullName string `json:"full_name"`
+		GitURL   string `json:"git_url"`
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+}
+
+type github struct {
+	verification verification.Service
+}
+
+func (*github) Name() string {
+	return "github"
+}
+
+// Match accepts requests with the GitHub event header, except those
+// of Gitea and Gogs which also send it for compatibility
+func (*github) Match(req *http.Request) bool {
+	return req.Header.Get("X-GitHub-Event") != "" && giteaEvent(req) == ""
+}
+
+func (g *github) Verify(req *http.Request, body []byte) (bool, error) {
+	return g.verification.Verify(req, body)
+}
+
+func (g *github) Parse(req *http.Request, body []byte) (*Event, error) {
+	gh := GHHookStruct{}
+	if err := json.Unmarshal(body, &gh); err != nil {
+		return nil, err
+	}
+	e := &Event{
+		Provider: g.Name(),
+		Type:     req.Header.Get("X-GitHub-Event"),
+		GitURL:   gh.Repository.GitURL,
+		Ref:      gh.Ref,
+		Commit:   gh.After,
+	}
+	switch e.Type {
+	case "create", "delete":
+		// create and delete carry the short ref name
+		e.Ref = qualifyRef(gh.RefType, gh.Ref)
+	case "pull_request":
+		e.Ref = qualifyRef("branch", gh.PullRequest.Head.Ref)
+		e.Commit = gh.PullRequest.Head.SHA
+		e.PullRequest = gh.Number
+	}
+	return e, nil
+}
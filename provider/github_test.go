@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func Test_github_Parse(t *testing.T) {
+	type args struct {
+		event string
+		body  string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *Event
+		wantErr bool
+	}{
+		{
+			name: "Parse Push",
+			args: args{
+				event: "push",
+				body: `{
+  "ref": "refs/heads/master",
+  "after": "bffeb74224043ba2feb48d137756c8a9331c449a",
+  "repository": {"git_url": "git://github.com/org/app.git"}
+}`,
+			},
+			want: &Event{
+				Provider: "github",
+				Type:     "push",
+				GitURL:   "git://github.com/org/app.git",
+				Ref:      "refs/heads/master",
+				Commit:   "bffeb74224043ba2feb48d137756c8a9331c449a",
+			},
+		},
+		{
+			name: "Parse Create Tag",
+			args: args{
+				event: "create",
+				body: `{
+  "ref": "v1.0.0",
+  "ref_type": "tag",
+  "repository": {"git_url": "git://github.com/org/app.git"}
+}`,
+			},
+			want: &Event{
+				Provider: "github",
+				Type:     "create",
+				GitURL:   "git://github.com/org/app.git",
+				Ref:      "refs/tags/v1.0.0",
+			},
+		},
+		{
+			name: "Parse Delete Branch",
+			args: args{
+				event: "delete",
+				body: `{
+  "ref": "feature",
+  "ref_type": "branch",
+  "repository": {"git_url": "git://github.com/org/app.git"}
+}`,
+			},
+			want: &Event{
+				Provider: "github",
+				Type:     "delete",
+				GitURL:   "git://github.com/org/app.git",
+				Ref:      "refs/heads/feature",
+			},
+		},
+		{
+			name: "Parse Pull Request",
+			args: args{
+				event: "pull_request",
+				body: `{
+  "number": 12,
+  "pull_request": {"head": {"ref": "feature", "sha": "53d54ac915144006c2c9e90d2c7d3880920db49c"}},
+  "repository": {"git_url": "git://github.com/org/app.git"}
+}`,
+			},
+			want: &Event{
+				Provider:    "github",
+				Type:        "pull_request",
+				GitURL:      "git://github.com/org/app.git",
+				Ref:         "refs/heads/feature",
+				Commit:      "53d54ac915144006c2c9e90d2c7d3880920db49c",
+				PullRequest: 12,
+			},
+		},
+		{
+			name:    "Parse Malformed",
+			args:    args{event: "push", body: `{`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{Header: http.Header{}}
+			req.Header.Set("X-GitHub-Event", tt.args.event)
+			got, err := (&github{}).Parse(req, []byte(tt.args.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("github.Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("github.Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
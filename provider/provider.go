@@ -0,0 +1,131 @@
+package provider
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-webhook/configuration"
+	"github.com/fabric8-services/fabric8-webhook/verification"
+)
+
+// ErrUnsupportedEvent is returned when a delivery carries an event
+// type the provider doesn't forward
+var ErrUnsupportedEvent = errors.New("Unsupported event type")
+
+// Event is the normalized form of a webhook delivery, independent
+// of the git hosting service it originates from
+type Event struct {
+	// Provider is the name of the provider which parsed the delivery
+	Provider string
+	// Type of the event e.g. push, create, delete, pull_request
+	Type string
+	// GitURL is the repository URL used to resolve the environment
+	GitURL string
+	// Ref is the fully qualified ref e.g. refs/heads/master
+	Ref string
+	// Commit is the SHA the ref points to after the event
+	Commit string
+	// PullRequest is the number of the pull request of
+	// pull request events, 0 otherwise
+	PullRequest int
+}
+
+// Provider verifies and parses webhook deliveries
+// of a git hosting service
+type Provider interface {
+	// Name of the provider
+	Name() string
+	// Match tells whether the request originates from this provider
+	Match(req *http.Request) bool
+	// Verify verifies whether the delivery came from approved source
+	Verify(req *http.Request, body []byte) (bool, error)
+	// Parse normalizes the delivery into an Event
+	Parse(req *http.Request, body []byte) (*Event, error)
+}
+
+// Service defines lookup of the provider for a delivery
+type Service interface {
+	Detect(req *http.Request) Provider
+	// Generic returns the provider of a configured generic source
+	Generic(source string) (Provider, error)
+	// SecretHeaders returns the headers carrying the tokens of
+	// the generic sources, redacted wherever deliveries are shown
+	SecretHeaders() []string
+}
+
+// serviceConfiguration the Configuration needed by providers
+type serviceConfiguration interface {
+	GetGiteaSecret() string
+	GetAzureUsername() string
+	GetAzurePassword() string
+	GetGenericSources() (map[string]configuration.GenericSource, error)
+}
+
+type service struct {
+	providers []Provider
+	// fallback is used for requests which no provider matches
+	fallback Provider
+	// generic providers by source
+	generic map[string]Provider
+	// secretHeaders carry the tokens of the generic sources
+	secretHeaders []string
+}
+
+// New returns a provider service instance
+func New(config serviceConfiguration, vs verification.Service) (Service, error) {
+	sources, err := config.GetGenericSources()
+	if err != nil {
+		return nil, err
+	}
+	generic := map[string]Provider{}
+	var secretHeaders []string
+	for name, source := range sources {
+		g, err := newGeneric(name, source)
+		if err != nil {
+			return nil, err
+		}
+		generic[name] = g
+		if source.Token != "" {
+			secretHeaders = append(secretHeaders, g.tokenHeader())
+		}
+	}
+
+	gh := &github{verification: vs}
+	return &service{
+		providers: []Provider{
+			gh,
+			&gitea{secret: config.GetGiteaSecret()},
+			&azure{
+				username: config.GetAzureUsername(),
+				password: config.GetAzurePassword(),
+			},
+		},
+		fallback:      gh,
+		generic:       generic,
+		secretHeaders: secretHeaders,
+	}, nil
+}
+
+// Detect returns the provider the request originates from. Requests
+// which no provider matches are treated as GitHub deliveries.
+func (s *service) Detect(req *http.Request) Provider {
+	for _, p := range s.providers {
+		if p.Match(req) {
+			return p
+		}
+	}
+	return s.fallback
+}
+
+func (s *service) Generic(source string) (Provider, error) {
+	p, ok := s.generic[strings.ToLower(source)]
+	if !ok {
+		return nil, ErrUnknownSource
+	}
+	return p, nil
+}
+
+func (s *service) SecretHeaders() []string {
+	return s.secretHeaders
+}
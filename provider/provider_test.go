@@ -0,0 +1,34 @@
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_service_Detect(t *testing.T) {
+	gh := &github{}
+	s := &service{
+		providers: []Provider{gh, &gitea{}, &azure{}},
+		fallback:  gh,
+	}
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{name: "GitHub", header: http.Header{"X-Github-Event": {"push"}}, want: "github"},
+		{name: "Gitea", header: http.Header{"X-Gitea-Event": {"push"}, "X-Github-Event": {"push"}, "X-Gogs-Event": {"push"}}, want: "gitea"},
+		{name: "Gogs", header: http.Header{"X-Gogs-Event": {"push"}, "X-Github-Event": {"push"}}, want: "gitea"},
+		{name: "Unknown", header: http.Header{}, want: "github"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/webhook", nil)
+			req.Header = tt.header
+			if got := s.Detect(req).Name(); got != tt.want {
+				t.Errorf("service.Detect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package registry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-webhook/configuration"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+)
+
+// ErrUnknownPayload is returned when the body is neither a Docker
+// Registry notification envelope nor a Quay repository push
+var ErrUnknownPayload = errors.New("Unknown registry payload")
+
+// Image identifies a pushed image tag
+type Image struct {
+	// Repository including the registry host e.g. quay.io/org/app
+	Repository string
+	Tag        string
+}
+
+func (i Image) String() string {
+	return i.Repository + ":" + i.Tag
+}
+
+// Service defines handling of container registry push events
+type Service interface {
+	// Verify verifies whether the notification came from approved source
+	Verify(req *http.Request, body []byte) bool
+	// Parse returns the images pushed according to the notification
+	Parse(body []byte) ([]Image, error)
+	// Targets returns the targets triggering the Jenkins jobs whose
+	// rules match the images, through the routes of the repositories
+	Targets(images []Image) ([]forward.Target, error)
+}
+
+// serviceConfiguration the Configuration needed by the registry service
+type serviceConfiguration interface {
+	GetRegistryToken() string
+	GetRegistrySecret() string
+	GetRegistryRules() ([]configuration.RegistryRule, error)
+}
+
+type service struct {
+	token   string
+	secret  string
+	rules   []configuration.RegistryRule
+	routing routing.Service
+}
+
+// New returns a registry service instance triggering
+// the jobs on the targets of the routes
+func New(config serviceConfiguration, routes routing.Service) (Service, error) {
+	rules, err := config.GetRegistryRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		// path.Match only reports malformed patterns while matching
+		if _, err := path.Match(r.Image, ""); err != nil {
+			return nil, fmt.Errorf("Invalid image pattern %q: %v", r.Image, err)
+		}
+	}
+	return &service{
+		token:   config.GetRegistryToken(),
+		secret:  config.GetRegistrySecret(),
+		rules:   rules,
+		routing: routes,
+	}, nil
+}
+
+// Verify accepts either the HMAC SHA256 signature of the body in
+// X-Registry-Signature, or the token as `Authorization: Token <token>`
+// (Docker Registry endpoint headers) or `?token=` (Quay webhook URL)
+func (s *service) Verify(req *http.Request, body []byte) bool {
+	if sig := req.Header.Get("X-Registry-Signature"); sig != "" && s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		return hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(sig))
+	}
+	if s.token == "" {
+		return false
+	}
+	token := req.URL.Query().Get("token")
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		token = strings.TrimPrefix(strings.TrimPrefix(auth, "Bearer "), "Token ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// notification holds the fields used of a Docker Registry
+// notification envelope and of a Quay repository push
+type notification struct {
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+
+	DockerURL   string   `json:"docker_url"`
+	UpdatedTags []string `json:"updated_tags"`
+}
+
+func (s *service) Parse(body []byte) ([]Image, error) {
+	n := notification{}
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, err
+	}
+
+	var images []Image
+	switch {
+	case n.Events != nil:
+		for _, e := range n.Events {
+			// Layer pushes carry no tag, only the manifest push does
+			if e.Action != "push" || e.Target.Tag == "" {
+				continue
+			}
+			repo := e.Target.Repository
+			if e.Request.Host != "" {
+				repo = e.Request.Host + "/" + repo
+			}
+			images = append(images, Image{Repository: repo, Tag: e.Target.Tag})
+		}
+	case n.DockerURL != "":
+		for _, tag := range n.UpdatedTags {
+			images = append(images, Image{Repository: n.DockerURL, Tag: tag})
+		}
+	default:
+		return nil, ErrUnknownPayload
+	}
+	return images, nil
+}
+
+// job is a job to trigger on the route of the repository
+type job struct {
+	repository string
+	name       string
+}
+
+// jobs returns the jobs of all rules matching the images, without duplicates
+func (s *service) jobs(images []Image) []job {
+	var jobs []job
+	seen := map[job]bool{}
+	for _, i := range images {
+		for _, r := range s.rules {
+			if ok, _ := path.Match(r.Image, i.String()); !ok {
+				continue
+			}
+			repository := r.Repository
+			if repository == "" {
+				repository = i.Repository
+			}
+			for _, name := range r.Jobs {
+				j := job{repository: repository, name: strings.Trim(name, "/")}
+				if !seen[j] {
+					seen[j] = true
+					jobs = append(jobs, j)
+				}
+			}
+		}
+	}
+	return jobs
+}
+
+func (s *service) Targets(images []Image) ([]forward.Target, error) {
+	var targets []forward.Target
+	for _, j := range s.jobs(images) {
+		route, err := s.routing.Resolve(j.repository, "")
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %v", j.name, err)
+		}
+		for _, t := range route.ForwardTargets() {
+			if t.Type != "" && t.Type != forward.TypeJenkins {
+				return nil, fmt.Errorf("job %s: the route of %s has %s targets", j.name, j.repository, t.Type)
+			}
+			// the job is built on the target with its credentials,
+			// retries and limits, the route's trigger does not apply
+			t.Trigger = forward.Trigger{Job: j.name}
+			t.Event = forward.Event{GitURL: j.repository}
+			targets = append(targets, t)
+		}
+	}
+	return targets, nil
+}
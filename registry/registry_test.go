@@ -0,0 +1,180 @@
+package registry
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-webhook/configuration"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+)
+
+func Test_service_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []Image
+		wantErr bool
+	}{
+		{
+			name: "Parse Docker Registry Envelope",
+			body: `{
+  "events": [
+    {
+      "action": "push",
+      "target": {
+        "mediaType": "application/octet-stream",
+        "repository": "library/app"
+      },
+      "request": {"host": "registry.example.com:5000"}
+    },
+    {
+      "action": "push",
+      "target": {
+        "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+        "repository": "library/app",
+        "tag": "latest"
+      },
+      "request": {"host": "registry.example.com:5000"}
+    },
+    {
+      "action": "pull",
+      "target": {
+        "repository": "library/app",
+        "tag": "latest"
+      },
+      "request": {"host": "registry.example.com:5000"}
+    }
+  ]
+}`,
+			want: []Image{{Repository: "registry.example.com:5000/library/app", Tag: "latest"}},
+		},
+		{
+			name: "Parse Quay Repository Push",
+			body: `{
+  "repository": "mynamespace/repository",
+  "namespace": "mynamespace",
+  "name": "repository",
+  "docker_url": "quay.io/mynamespace/repository",
+  "updated_tags": ["latest", "v1"]
+}`,
+			want: []Image{
+				{Repository: "quay.io/mynamespace/repository", Tag: "latest"},
+				{Repository: "quay.io/mynamespace/repository", Tag: "v1"},
+			},
+		},
+		{
+			name:    "Parse Unknown Payload",
+			body:    `{"ref": "refs/heads/master"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&service{}).Parse([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("service.Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("service.Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_service_Verify(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/master"}`)
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		want   bool
+	}{
+		{
+			name:   "Verify Token Header Positive",
+			url:    "/api/webhook/registry",
+			header: http.Header{"Authorization": {"Token s3cr3t"}},
+			want:   true,
+		},
+		{
+			name: "Verify Token Query Positive",
+			url:  "/api/webhook/registry?token=s3cr3t",
+			want: true,
+		},
+		{
+			name:   "Verify Signature Positive",
+			url:    "/api/webhook/registry",
+			header: http.Header{"X-Registry-Signature": {"18bd702ca7dab5713101db346ec6cd6768820c090515db9744deff53bc95ff52"}},
+			want:   true,
+		},
+		{
+			name:   "Verify Token Negative",
+			url:    "/api/webhook/registry",
+			header: http.Header{"Authorization": {"Bearer other"}},
+			want:   false,
+		},
+		{
+			name: "Verify Missing Token Negative",
+			url:  "/api/webhook/registry",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			req := &http.Request{URL: u, Header: tt.header}
+			if req.Header == nil {
+				req.Header = http.Header{}
+			}
+			s := &service{token: "s3cr3t", secret: "secret"}
+			if got := s.Verify(req, body); got != tt.want {
+				t.Errorf("service.Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_service_Targets(t *testing.T) {
+	routes, err := routing.New(routing.NewStaticSource(&routing.Table{
+		Default: "http://jenkins",
+		Routes: []routing.Route{
+			{Repository: "github.com/org/app", Target: "http://app-jenkins",
+				Credentials: forward.Credentials{User: "admin", APIToken: "t"}},
+			{Org: "quay.io/tekton", Target: "http://listener", Type: forward.TypeTekton},
+		},
+	}), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &service{
+		routing: routes,
+		rules: []configuration.RegistryRule{
+			{Image: "quay.io/org/*:latest", Jobs: []string{"app/deploy", "smoke"}, Repository: "https://github.com/org/app.git"},
+			{Image: "quay.io/org/app:*", Jobs: []string{"/app/deploy/"}, Repository: "https://github.com/org/app.git"},
+			{Image: "quay.io/org/*:*", Jobs: []string{"other"}},
+			{Image: "quay.io/tekton/*:*", Jobs: []string{"pipeline"}},
+		},
+	}
+	got, err := s.Targets([]Image{{Repository: "quay.io/org/app", Tag: "latest"}})
+	if err != nil {
+		t.Fatalf("service.Targets() error = %v", err)
+	}
+	var jobs []string
+	for _, target := range got {
+		jobs = append(jobs, target.URL+" "+target.Trigger.Job)
+	}
+	want := []string{"http://app-jenkins app/deploy", "http://app-jenkins smoke", "http://jenkins other"}
+	if !reflect.DeepEqual(jobs, want) {
+		t.Errorf("service.Targets() = %v, want %v", jobs, want)
+	}
+	if got[0].Credentials.User != "admin" {
+		t.Errorf("service.Targets() credentials = %+v, want those of the route", got[0].Credentials)
+	}
+
+	if _, err := s.Targets([]Image{{Repository: "quay.io/tekton/app", Tag: "latest"}}); err == nil {
+		t.Errorf("service.Targets() on tekton route error = nil, want an error")
+	}
+}
@@ -0,0 +1,105 @@
+package resolver
+
+import (
+	"errors"
+
+	"github.com/fabric8-services/fabric8-webhook/build"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/osd"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+)
+
+var (
+	// ErrInvalidEnvironment is returned for repositories
+	// of an unknown environment type
+	ErrInvalidEnvironment = errors.New("Invalid Environment Type")
+	// ErrTargetRemoved is returned when the repository
+	// is no longer routed to the target
+	ErrTargetRemoved = errors.New("Target is no longer routed")
+)
+
+// Service resolves the targets of the deliveries of a repository
+// according to the environment of the repository
+type Service interface {
+	forward.Resolver
+	// Targets returns the targets of the event and the
+	// policy deriving the response to the sender
+	Targets(e forward.Event) ([]forward.Target, forward.Policy, error)
+}
+
+type service struct {
+	build   build.Service
+	routing routing.Service
+	osd     osd.Service
+}
+
+// New returns a resolver service instance
+func New(bs build.Service, routes routing.Service, osds osd.Service) Service {
+	return &service{build: bs, routing: routes, osd: osds}
+}
+
+func (s *service) Targets(e forward.Event) ([]forward.Target, forward.Policy, error) {
+	tenant, err := s.build.GetTenant(e.GitURL)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.targets(e, tenant)
+}
+
+// Resolve returns the target as currently routed for the repository
+// and tenant of the delivery. The event and the trigger are those of
+// the delivery, registry deliveries set the job per delivery.
+func (s *service) Resolve(t forward.Target) (forward.Target, error) {
+	targets, _, err := s.targets(t.Event, t.Tenant)
+	if err != nil {
+		return t, err
+	}
+	for _, cur := range targets {
+		if cur.URL == t.URL {
+			cur.Trigger = t.Trigger
+			return cur, nil
+		}
+	}
+	return t, ErrTargetRemoved
+}
+
+// targets resolves the targets of the event to the repository of
+// tenant. Deliveries without tenant are routed by the routing table.
+func (s *service) targets(e forward.Event, tenant string) ([]forward.Target, forward.Policy, error) {
+	envType := "OSIO"
+	if tenant != "" {
+		var err error
+		if envType, err = s.build.GetEnvironmentType(e.GitURL); err != nil {
+			return nil, "", err
+		}
+	}
+	var targets []forward.Target
+	policy := forward.PolicyPrimary
+	switch envType {
+	case "OSIO":
+		route, err := s.routing.Resolve(e.GitURL, tenant)
+		if err != nil {
+			return nil, "", err
+		}
+		targets = route.ForwardTargets()
+		if route.Response != "" {
+			policy = route.Response
+		}
+	case "OSD":
+		endpoint, err := s.osd.Resolve(tenant)
+		if err != nil {
+			return nil, "", err
+		}
+		targets = append(targets, forward.Target{
+			URL:   endpoint.URL,
+			Token: endpoint.Token,
+		})
+	default:
+		return nil, "", ErrInvalidEnvironment
+	}
+	for i := range targets {
+		targets[i].Tenant = tenant
+		targets[i].Event = e
+	}
+	return targets, policy, nil
+}
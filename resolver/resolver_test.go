@@ -0,0 +1,131 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/osd"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+)
+
+// builds owns the repositories of org by alice, an OSIO tenant,
+// those of osd by bob, an OSD tenant, and those of new by carol,
+// an OSD tenant without cluster
+type builds struct{}
+
+func (builds) GetEnvironmentType(gitURL string) (string, error) {
+	switch routing.RepositoryPath(gitURL) {
+	case "github.com/osd/app", "github.com/new/app":
+		return "OSD", nil
+	}
+	return "OSIO", nil
+}
+
+func (builds) GetTenant(gitURL string) (string, error) {
+	switch routing.RepositoryPath(gitURL) {
+	case "github.com/org/app":
+		return "alice", nil
+	case "github.com/osd/app":
+		return "bob", nil
+	case "github.com/new/app":
+		return "carol", nil
+	}
+	return "", nil
+}
+
+type clusters struct {
+	token string
+}
+
+func (c clusters) Resolve(tenant string) (*osd.Endpoint, error) {
+	if tenant != "bob" {
+		return nil, osd.ErrTenantNotConfigured
+	}
+	return &osd.Endpoint{URL: "http://jenkins.bob", Token: c.token}, nil
+}
+
+func newService(t *testing.T, table *routing.Table, token string) Service {
+	routes, err := routing.New(routing.NewStaticSource(table), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(builds{}, routes, clusters{token: token})
+}
+
+func Test_service_Targets(t *testing.T) {
+	s := newService(t, &routing.Table{
+		Routes: []routing.Route{
+			{Tenant: "alice", Target: "http://jenkins.alice", Targets: []string{"http://mirror"}, Response: forward.PolicyAll},
+		},
+	}, "t")
+	tests := []struct {
+		name       string
+		gitURL     string
+		want       []string
+		wantTenant string
+		wantPolicy forward.Policy
+		wantErr    error
+	}{
+		{name: "OSIO", gitURL: "https://github.com/org/app", want: []string{"http://jenkins.alice", "http://mirror"}, wantTenant: "alice", wantPolicy: forward.PolicyAll},
+		{name: "OSD", gitURL: "https://github.com/osd/app", want: []string{"http://jenkins.bob"}, wantTenant: "bob", wantPolicy: forward.PolicyPrimary},
+		{name: "No Route", gitURL: "https://github.com/other/app", wantErr: routing.ErrNoRoute},
+		{name: "OSD Tenant Not Configured", gitURL: "https://github.com/new/app", wantErr: osd.ErrTenantNotConfigured},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, policy, err := s.Targets(forward.Event{GitURL: tt.gitURL})
+			if err != tt.wantErr {
+				t.Fatalf("service.Targets() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(targets) != len(tt.want) || policy != tt.wantPolicy {
+				t.Fatalf("service.Targets() = %+v, %v, want %v, %v", targets, policy, tt.want, tt.wantPolicy)
+			}
+			for i, target := range targets {
+				if target.URL != tt.want[i] || target.Tenant != tt.wantTenant || target.Event.GitURL != tt.gitURL {
+					t.Errorf("service.Targets()[%d] = %+v, want %v of %v", i, target, tt.want[i], tt.wantTenant)
+				}
+			}
+		})
+	}
+}
+
+func Test_service_Resolve(t *testing.T) {
+	s := newService(t, &routing.Table{
+		Routes: []routing.Route{
+			{Repository: "github.com/org/*", Target: "http://jenkins.alice",
+				Credentials: forward.Credentials{User: "admin", APIToken: "current"}},
+		},
+	}, "current")
+	event := forward.Event{GitURL: "https://github.com/org/app", Ref: "refs/heads/master"}
+	tests := []struct {
+		name      string
+		target    forward.Target
+		wantToken string
+		wantErr   error
+	}{
+		{name: "Route", target: forward.Target{URL: "http://jenkins.alice", Tenant: "alice", Event: event,
+			Trigger: forward.Trigger{Job: "app"}}, wantToken: "current"},
+		{name: "Registry Job", target: forward.Target{URL: "http://jenkins.alice", Event: event,
+			Trigger: forward.Trigger{Job: "app"}}, wantToken: "current"},
+		{name: "OSD", target: forward.Target{URL: "http://jenkins.bob", Tenant: "bob",
+			Event: forward.Event{GitURL: "https://github.com/osd/app"}}, wantToken: "current"},
+		{name: "Removed", target: forward.Target{URL: "http://old", Tenant: "alice", Event: event}, wantErr: ErrTargetRemoved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Resolve(tt.target)
+			if err != tt.wantErr {
+				t.Fatalf("service.Resolve() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if token := got.Token + got.Credentials.APIToken; token != tt.wantToken {
+				t.Errorf("service.Resolve() token = %v, want %v", token, tt.wantToken)
+			}
+			if got.Tenant != tt.target.Tenant || got.Event != tt.target.Event || got.Trigger.Job != tt.target.Trigger.Job {
+				t.Errorf("service.Resolve() = %+v, want the tenant, event and trigger of %+v", got, tt.target)
+			}
+		})
+	}
+}
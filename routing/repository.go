@@ -0,0 +1,34 @@
+package routing
+
+import (
+	"net/url"
+	"strings"
+)
+
+// RepositoryPath normalizes the different git URL forms, e.g.
+// git://github.com/org/repo.git, https://github.com/org/repo.git
+// and git@github.com:org/repo.git, into host/org/repo
+func RepositoryPath(gitURL string) string {
+	s := gitURL
+	if !strings.Contains(s, "://") {
+		// scp-like syntax user@host:path
+		if i := strings.Index(s, ":"); i >= 0 {
+			s = "ssh://" + s[:i] + "/" + s[i+1:]
+		}
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return strings.TrimSuffix(strings.Trim(gitURL, "/"), ".git")
+	}
+	p := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	return strings.ToLower(u.Hostname()) + "/" + p
+}
+
+// orgPath returns host/org of a normalized repository path
+func orgPath(repo string) string {
+	parts := strings.SplitN(repo, "/", 3)
+	if len(parts) < 2 {
+		return repo
+	}
+	return parts[0] + "/" + parts[1]
+}
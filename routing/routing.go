@@ -0,0 +1,327 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+)
+
+// ErrNoRoute is returned when no route matches a
+// delivery and the table has no default route
+var ErrNoRoute = errors.New("No route matches the repository")
+
+// Route maps deliveries to a target. Exactly one of
+// Repository, Org or Tenant is set.
+type Route struct {
+	// Repository is a path.Match pattern on host/org/repo
+	// e.g. github.com/fabric8-services/*
+	Repository string `mapstructure:"repository"`
+	// Org is a path.Match pattern on host/org e.g. github.com/fabric8-services
+	Org string `mapstructure:"org"`
+	// Tenant is the exact tenant owning the repository
+	Tenant string `mapstructure:"tenant"`
+	// Target is the URL deliveries are forwarded to
+	Target string `mapstructure:"target"`
+	// Targets are further URLs deliveries are forwarded to
+	Targets []string `mapstructure:"targets"`
+	// Response defines how the response to the sender is
+	// derived from the outcomes of the targets
+	Response forward.Policy `mapstructure:"response"`
+	// Retry caps the retries of failed forwards to the targets
+	Retry forward.Retry `mapstructure:"retry"`
+	// Limits cap the concurrency and rate of forwards to each target,
+	// routes sharing a target set the same limits
+	Limits forward.Limits `mapstructure:"limits"`
+	// Transport configures the connections to each target
+	Transport forward.Transport `mapstructure:"transport"`
+	// Headers defines the headers of the requests to each target
+	Headers forward.HeaderPolicy `mapstructure:"headers"`
+	// Credentials authenticate the requests to each target
+	Credentials forward.Credentials `mapstructure:"credentials"`
+	// Wake wakes each target when idled
+	Wake forward.Wake `mapstructure:"wake"`
+	// Type defines how deliveries are sent to the targets,
+	// jenkins if empty
+	Type forward.TargetType `mapstructure:"type"`
+	// Trigger calls the Jenkins REST API of each target
+	// instead of forwarding the payload
+	Trigger forward.Trigger `mapstructure:"trigger"`
+	// BuildConfig configures the webhook of buildconfig targets
+	BuildConfig forward.BuildConfig `mapstructure:"buildconfig"`
+	// Transform renders the body of the requests to each target
+	Transform forward.Transform `mapstructure:"transform"`
+	// Debounce coalesces the pushes to the same repository and ref
+	// within the window, only the latest is forwarded
+	Debounce time.Duration `mapstructure:"debounce"`
+	// Ordering forwards the deliveries of a repository, or of a
+	// ref of a repository, serially in arrival order
+	Ordering forward.Ordering `mapstructure:"ordering"`
+}
+
+// AllTargets returns Target followed by Targets, the first
+// one is the primary target
+func (r *Route) AllTargets() []string {
+	var all []string
+	if r.Target != "" {
+		all = append(all, r.Target)
+	}
+	return append(all, r.Targets...)
+}
+
+// ForwardTargets returns the targets of the route with the
+// retry caps, limits and transport of the route
+func (r *Route) ForwardTargets() []forward.Target {
+	var targets []forward.Target
+	for _, t := range r.AllTargets() {
+		targets = append(targets, forward.Target{
+			URL:         t,
+			Type:        r.Type,
+			Retry:       r.Retry,
+			Limits:      r.Limits,
+			Transport:   r.Transport,
+			Headers:     r.Headers,
+			Credentials: r.Credentials,
+			Wake:        r.Wake,
+			Trigger:     r.Trigger,
+			BuildConfig: r.BuildConfig,
+			Transform:   r.Transform,
+			Debounce:    r.Debounce,
+			Ordering:    r.Ordering,
+		})
+	}
+	return targets
+}
+
+// validateType checks the type of the targets
+// and the settings specific to the type
+func (r *Route) validateType() error {
+	if err := r.Type.Validate(); err != nil {
+		return err
+	}
+	if err := r.Trigger.Validate(); err != nil {
+		return err
+	}
+	if r.Trigger.Job != "" && r.Type != "" && r.Type != forward.TypeJenkins {
+		return fmt.Errorf("trigger requires jenkins targets")
+	}
+	if r.Type == forward.TypeBuildConfig {
+		return r.BuildConfig.Validate()
+	}
+	return nil
+}
+
+// Table is the routing table
+type Table struct {
+	// Default is the target used when no route matches,
+	// deliveries are rejected if empty
+	Default string  `mapstructure:"default"`
+	Routes  []Route `mapstructure:"routes"`
+}
+
+// Targets returns the targets of every route and the default target
+func (t *Table) Targets() []forward.Target {
+	var targets []forward.Target
+	for i := range t.Routes {
+		targets = append(targets, t.Routes[i].ForwardTargets()...)
+	}
+	if t.Default != "" {
+		targets = append(targets, forward.Target{URL: t.Default})
+	}
+	return targets
+}
+
+// Validate checks every route has one valid pattern and a target, and
+// that routes forwarding to the same target agree on its limits
+func (t *Table) Validate() error {
+	first := map[string]int{}
+	for i, r := range t.Routes {
+		n := 0
+		for _, p := range []string{r.Repository, r.Org, r.Tenant} {
+			if p != "" {
+				n++
+			}
+		}
+		if n != 1 {
+			return fmt.Errorf("route %d: exactly one of repository, org or tenant must be set", i)
+		}
+		if len(r.AllTargets()) == 0 {
+			return fmt.Errorf("route %d: target is required", i)
+		}
+		if err := r.Response.Validate(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if err := r.Retry.Validate(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if err := r.Limits.Validate(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		for _, target := range r.AllTargets() {
+			j, ok := first[target]
+			if !ok {
+				first[target] = i
+				continue
+			}
+			if t.Routes[j].Limits != r.Limits {
+				return fmt.Errorf("route %d: limits of %s differ from those of route %d", i, target, j)
+			}
+		}
+		if err := r.Transport.Validate(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if err := r.Headers.Validate(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if err := r.Credentials.Validate(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if err := r.Wake.Validate(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if err := r.validateType(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if err := r.Transform.Validate(); err != nil {
+			return fmt.Errorf("route %d: transform: %v", i, err)
+		}
+		if r.Debounce < 0 {
+			return fmt.Errorf("route %d: debounce must not be negative", i)
+		}
+		if err := r.Ordering.Validate(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if _, err := path.Match(r.Repository+r.Org, ""); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Service defines resolution of the target of deliveries
+type Service interface {
+	// Resolve returns the route of a delivery for the repository
+	// at gitURL, owned by tenant which may be unknown
+	Resolve(gitURL, tenant string) (*Route, error)
+	// Reload reads the routing table from the source again
+	Reload() error
+	// OnReload calls f with the current routing table,
+	// then with the new one on every reload
+	OnReload(f func(*Table))
+}
+
+type service struct {
+	source  Source
+	lock    sync.RWMutex
+	table   *Table
+	reloads []func(*Table)
+}
+
+// New returns a routing service instance, which reloads
+// the routing table every duration unless it is zero
+func New(source Source, duration time.Duration) (Service, error) {
+	s := &service{source: source}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	if duration > 0 {
+		go s.monitor(time.NewTicker(duration))
+	}
+	return s, nil
+}
+
+// monitor reloads the routing table on every tick of the ticker
+func (s *service) monitor(ticker *time.Ticker) {
+	for range ticker.C {
+		if err := s.Reload(); err != nil {
+			log.Error(nil, map[string]interface{}{
+				"err": err,
+			}, "failed to reload the routing table")
+		}
+	}
+}
+
+func (s *service) Reload() error {
+	t, err := s.source.Table()
+	if err != nil {
+		return err
+	}
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.table = t
+	reloads := s.reloads
+	s.lock.Unlock()
+	for _, f := range reloads {
+		f(t)
+	}
+	return nil
+}
+
+func (s *service) OnReload(f func(*Table)) {
+	s.lock.Lock()
+	s.reloads = append(s.reloads, f)
+	t := s.table
+	s.lock.Unlock()
+	f(t)
+}
+
+// Resolve picks the most specific matching route: repository routes
+// win over org routes, which win over tenant routes. Among routes of
+// the same kind the longest pattern wins, the first one on ties.
+func (s *service) Resolve(gitURL, tenant string) (*Route, error) {
+	s.lock.RLock()
+	t := s.table
+	s.lock.RUnlock()
+
+	repo := RepositoryPath(gitURL)
+	org := orgPath(repo)
+
+	var best *Route
+	bestScore := -1
+	for i := range t.Routes {
+		r := &t.Routes[i]
+		score := -1
+		switch {
+		case r.Repository != "":
+			if ok, _ := path.Match(r.Repository, repo); ok {
+				score = 2<<16 + literalLen(r.Repository)
+			}
+		case r.Org != "":
+			if ok, _ := path.Match(r.Org, org); ok {
+				score = 1<<16 + literalLen(r.Org)
+			}
+		case r.Tenant != "":
+			if tenant != "" && r.Tenant == tenant {
+				score = 0
+			}
+		}
+		if score > bestScore {
+			best, bestScore = r, score
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+	if t.Default != "" {
+		return &Route{Target: t.Default}, nil
+	}
+	return nil, ErrNoRoute
+}
+
+// literalLen is the length of pattern without wildcards
+func literalLen(pattern string) int {
+	n := 0
+	for _, c := range pattern {
+		if !strings.ContainsRune(`*?[]\`, c) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,257 @@
+package routing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-webhook/forward"
+)
+
+func TestRepositoryPath(t *testing.T) {
+	tests := []struct {
+		gitURL string
+		want   string
+	}{
+		{"git://github.com/fabric8-services/fabric8-webhook.git", "github.com/fabric8-services/fabric8-webhook"},
+		{"https://GitHub.com/fabric8-services/fabric8-webhook.git", "github.com/fabric8-services/fabric8-webhook"},
+		{"git@github.com:fabric8-services/fabric8-webhook.git", "github.com/fabric8-services/fabric8-webhook"},
+		{"ssh://git@gitea.example.com:2222/org/repo.git", "gitea.example.com/org/repo"},
+		{"https://dev.azure.com/org/project/_git/repo", "dev.azure.com/org/project/_git/repo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.gitURL, func(t *testing.T) {
+			if got := RepositoryPath(tt.gitURL); got != tt.want {
+				t.Errorf("RepositoryPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_service_Resolve(t *testing.T) {
+	table := &Table{
+		Default: "http://default",
+		Routes: []Route{
+			{Tenant: "alice", Target: "http://tenant-alice"},
+			{Org: "github.com/fabric8-services", Target: "http://org"},
+			{Repository: "github.com/fabric8-services/*", Target: "http://repo-glob"},
+			{Repository: "github.com/fabric8-services/fabric8-webhook", Target: "http://repo"},
+			{Org: "github.com/*", Target: "http://org-glob"},
+		},
+	}
+	type args struct {
+		gitURL string
+		tenant string
+	}
+	tests := []struct {
+		name    string
+		table   *Table
+		args    args
+		want    string
+		wantErr error
+	}{
+		{
+			name: "Exact Repository Wins Over Glob",
+			args: args{gitURL: "git://github.com/fabric8-services/fabric8-webhook.git", tenant: "alice"},
+			want: "http://repo",
+		},
+		{
+			name: "Repository Glob Wins Over Org",
+			args: args{gitURL: "https://github.com/fabric8-services/fabric8-common.git"},
+			want: "http://repo-glob",
+		},
+		{
+			name: "Longest Org",
+			args: args{gitURL: "https://github.com/fabric8-services/a/b.git"},
+			want: "http://org",
+		},
+		{
+			name: "Org Glob Wins Over Tenant",
+			args: args{gitURL: "https://github.com/khrm/test.git", tenant: "alice"},
+			want: "http://org-glob",
+		},
+		{
+			name: "Tenant",
+			args: args{gitURL: "https://gitea.example.com/khrm/test.git", tenant: "alice"},
+			want: "http://tenant-alice",
+		},
+		{
+			name: "Default",
+			args: args{gitURL: "https://gitea.example.com/khrm/test.git", tenant: "bob"},
+			want: "http://default",
+		},
+		{
+			name:    "No Route",
+			table:   &Table{Routes: table.Routes},
+			args:    args{gitURL: "https://gitea.example.com/khrm/test.git"},
+			wantErr: ErrNoRoute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tbl := tt.table
+			if tbl == nil {
+				tbl = table
+			}
+			s, err := New(NewStaticSource(tbl), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.Resolve(tt.args.gitURL, tt.args.tenant)
+			if err != tt.wantErr {
+				t.Errorf("service.Resolve() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.Target != tt.want {
+				t.Errorf("service.Resolve() = %v, want %v", got.Target, tt.want)
+			}
+		})
+	}
+}
+
+func TestTable_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  []Route
+		wantErr bool
+	}{
+		{name: "Valid", routes: []Route{{Org: "github.com/org", Target: "http://t"}}},
+		{name: "No Pattern", routes: []Route{{Target: "http://t"}}, wantErr: true},
+		{name: "Two Patterns", routes: []Route{{Org: "github.com/org", Tenant: "t", Target: "http://t"}}, wantErr: true},
+		{name: "No Target", routes: []Route{{Org: "github.com/org"}}, wantErr: true},
+		{name: "Malformed Pattern", routes: []Route{{Repository: "github.com/[", Target: "http://t"}}, wantErr: true},
+		{name: "Invalid Header Template", routes: []Route{{Org: "github.com/org", Target: "http://t", Headers: forward.HeaderPolicy{Set: map[string]string{"X-A": "{{.Tenantt}}"}}}}, wantErr: true},
+		{name: "Unknown Type", routes: []Route{{Org: "github.com/org", Target: "http://t", Type: "travis"}}, wantErr: true},
+		{name: "BuildConfig Without Secret", routes: []Route{{Org: "github.com/org", Target: "http://t", Type: forward.TypeBuildConfig}}, wantErr: true},
+		{name: "Trigger Tekton", routes: []Route{{Org: "github.com/org", Target: "http://t", Type: forward.TypeTekton, Trigger: forward.Trigger{Job: "app"}}}, wantErr: true},
+		{name: "Negative Limits", routes: []Route{{Org: "github.com/org", Target: "http://t", Limits: forward.Limits{MaxInFlight: -1}}}, wantErr: true},
+		{name: "Conflicting Limits", routes: []Route{
+			{Org: "github.com/org", Target: "http://t", Limits: forward.Limits{MaxInFlight: 1}},
+			{Org: "github.com/other", Targets: []string{"http://t"}, Limits: forward.Limits{MaxInFlight: 2}},
+		}, wantErr: true},
+		{name: "Shared Limits", routes: []Route{
+			{Org: "github.com/org", Target: "http://t", Limits: forward.Limits{MaxInFlight: 1}},
+			{Org: "github.com/other", Target: "http://t", Limits: forward.Limits{MaxInFlight: 1}},
+		}},
+		{name: "Unknown Ordering", routes: []Route{{Org: "github.com/org", Target: "http://t", Ordering: "tenant"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (&Table{Routes: tt.routes}).Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Table.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_fileSource_Table(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "routes.yaml")
+	err = ioutil.WriteFile(file, []byte(`
+default: http://default
+routes:
+- repository: github.com/fabric8-services/*
+  target: http://repo
+- tenant: alice
+  target: http://alice
+  trigger:
+    job: app
+    parameters:
+      GIT_COMMIT: "{{.Commit}}"
+  transform:
+    projection:
+      repoName: $.repository.name
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewFileSource(file).Table()
+	if err != nil {
+		t.Fatalf("fileSource.Table() error = %v", err)
+	}
+	want := &Table{
+		Default: "http://default",
+		Routes: []Route{
+			{Repository: "github.com/fabric8-services/*", Target: "http://repo"},
+			{Tenant: "alice", Target: "http://alice",
+				Trigger:   forward.Trigger{Job: "app", Parameters: map[string]string{"GIT_COMMIT": "{{.Commit}}"}},
+				Transform: forward.Transform{Projection: map[string]string{"repoName": "$.repository.name"}}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fileSource.Table() = %+v, want %+v", got, want)
+	}
+}
+
+type tableStore struct {
+	doc []byte
+}
+
+func (s *tableStore) RoutingTable() ([]byte, error) {
+	if s.doc == nil {
+		return nil, ErrNoTable
+	}
+	return s.doc, nil
+}
+
+func (s *tableStore) SaveRoutingTable(doc []byte) error {
+	s.doc = doc
+	return nil
+}
+
+func Test_storeSource_Table(t *testing.T) {
+	store := &tableStore{}
+	source := NewStoreSource(store, NewStaticSource(&Table{Default: "http://proxy"}))
+	got, err := source.Table()
+	if err != nil || got.Default != "http://proxy" {
+		t.Errorf("storeSource.Table() without stored table = %v, %v, want the fallback", got, err)
+	}
+
+	store.SaveRoutingTable([]byte(`{"default": "http://default", "routes": [
+		{"tenant": "alice", "target": "http://alice", "retry": {"max_age": "10m"},
+		 "transform": {"projection": {"repoName": "$.repository.name"}}}]}`))
+	got, err = source.Table()
+	if err != nil {
+		t.Fatalf("storeSource.Table() error = %v", err)
+	}
+	want := &Table{
+		Default: "http://default",
+		Routes: []Route{{Tenant: "alice", Target: "http://alice", Retry: forward.Retry{MaxAge: 10 * time.Minute},
+			Transform: forward.Transform{Projection: map[string]string{"repoName": "$.repository.name"}}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("storeSource.Table() = %v, want %v", got, want)
+	}
+
+	store.SaveRoutingTable([]byte(`routes: [`))
+	if _, err := source.Table(); err == nil {
+		t.Errorf("storeSource.Table() with malformed table error = nil, want an error")
+	}
+}
+
+func Test_service_OnReload(t *testing.T) {
+	s, err := New(NewStaticSource(&Table{Default: "http://a"}), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	s.OnReload(func(t *Table) {
+		for _, target := range t.Targets() {
+			got = append(got, target.URL)
+		}
+	})
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://a", "http://a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OnReload() got targets %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package routing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+
+	"github.com/mitchellh/mapstructure"
+	errs "github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ErrNoTable is returned by table stores without routing table
+var ErrNoTable = errors.New("No routing table stored")
+
+// Source provides the routing table. Besides the routing file,
+// a storage backend can be a source so that routes are managed
+// without redeploying.
+type Source interface {
+	Table() (*Table, error)
+}
+
+// fileSource reads the routing table from a YAML or JSON file
+type fileSource struct {
+	path string
+}
+
+// NewFileSource returns a Source reading the routing table from path
+func NewFileSource(path string) Source {
+	return &fileSource{path: path}
+}
+
+func (f *fileSource) Table() (*Table, error) {
+	doc, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to read routing file %s", f.path)
+	}
+	t, err := decodeTable(doc)
+	if err != nil {
+		return nil, errs.Wrapf(err, "invalid routing file %s", f.path)
+	}
+	return t, nil
+}
+
+// ParseTable decodes a routing table document in the
+// YAML or JSON format of the routing file
+func ParseTable(doc []byte) (*Table, error) {
+	t, err := decodeTable(doc)
+	if err != nil {
+		return nil, errs.Wrap(err, "invalid routing table")
+	}
+	return t, nil
+}
+
+// decodeTable decodes the document into a table by the mapstructure
+// tags of its fields. Map keys are kept as written, job parameters
+// and projected members are case-sensitive.
+func decodeTable(doc []byte) (*Table, error) {
+	var raw interface{}
+	var err error
+	if trimmed := bytes.TrimSpace(doc); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(doc, &raw)
+	} else {
+		err = yaml.Unmarshal(doc, &raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{}
+	d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		WeaklyTypedInput: true,
+		Result:           t,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Decode(raw); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TableStore keeps the routing table document
+type TableStore interface {
+	// RoutingTable returns the document, ErrNoTable if none is stored
+	RoutingTable() ([]byte, error)
+	// SaveRoutingTable replaces the document
+	SaveRoutingTable(doc []byte) error
+}
+
+// storeSource reads the routing table from a storage backend
+type storeSource struct {
+	store    TableStore
+	fallback Source
+}
+
+// NewStoreSource returns a Source reading the routing table
+// from store, or from fallback until a table is stored
+func NewStoreSource(store TableStore, fallback Source) Source {
+	return &storeSource{store: store, fallback: fallback}
+}
+
+func (s *storeSource) Table() (*Table, error) {
+	doc, err := s.store.RoutingTable()
+	if err == ErrNoTable {
+		return s.fallback.Table()
+	}
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read the stored routing table")
+	}
+	return ParseTable(doc)
+}
+
+// staticSource is a fixed routing table
+type staticSource struct {
+	table *Table
+}
+
+// NewStaticSource returns a Source always providing table
+func NewStaticSource(table *Table) Source {
+	return &staticSource{table: table}
+}
+
+func (s *staticSource) Table() (*Table, error) {
+	return s.table, nil
+}
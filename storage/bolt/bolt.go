@@ -0,0 +1,606 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+	"github.com/fabric8-services/fabric8-webhook/storage"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// pollInterval is how often an idle worker looks for deliveries
+// whose worker's lease expired
+const pollInterval = time.Second
+
+// compactRatio is the share of free pages above which the file is compacted
+const compactRatio = 0.5
+
+// compactMinSize is the file size below which the file is never compacted
+const compactMinSize = 1 << 20
+
+var (
+	deliveriesBucket  = []byte("deliveries")
+	queueBucket       = []byte("queue")
+	deadLettersBucket = []byte("dead_letters")
+	routingBucket     = []byte("routing")
+)
+
+// storeConfiguration the Configuration needed by the bolt store
+type storeConfiguration interface {
+	GetStorageBoltPath() string
+	GetStorageRetention() time.Duration
+	GetStorageCompactionInterval() time.Duration
+	GetForwardQueueSize() int
+	GetForwardQueueLease() time.Duration
+}
+
+// record is a recorded delivery
+type record struct {
+	ID         string
+	Provider   string
+	Method     string
+	URL        string
+	Header     http.Header
+	Body       []byte
+	RemoteAddr string
+	Verified   bool
+	Targets    []forward.Target
+	Policy     forward.Policy
+	Status     storage.Status
+	Error      string
+	Attempts   []forward.Attempt
+	Created    time.Time
+	Updated    time.Time
+	// QueueKey is the key of the delivery in the queue bucket
+	QueueKey []byte
+}
+
+// queued is a delivery in the queue bucket, ordered by key
+type queued struct {
+	ID          string
+	LockedUntil time.Time
+	// NotBefore is when the delivery may be claimed
+	NotBefore time.Time
+	// OrderKey is the key of the deliveries claimed one at a time
+	OrderKey string
+}
+
+type store struct {
+	// lock is held exclusively while compacting, which replaces db
+	lock sync.RWMutex
+	db   *bbolt.DB
+	// reopenErr is the error of reopening the file after compacting
+	// it, the file is opened again on the next transaction
+	reopenErr error
+	path      string
+	// size is the maximum number of queued deliveries
+	size int
+	// lease is how long a worker owns a delivery it popped
+	lease     time.Duration
+	retention time.Duration
+	// pushed wakes up a waiting worker
+	pushed chan struct{}
+	closed chan struct{}
+}
+
+// New opens the bolt file, creating it if needed, compacts it and
+// returns a storage.Store instance. Every write is committed to disk
+// before returning, deliveries claimed by a worker which crashed are
+// claimed again once the lease expired.
+func New(config storeConfiguration) (storage.Store, error) {
+	s := &store{
+		path:      config.GetStorageBoltPath(),
+		size:      config.GetForwardQueueSize(),
+		lease:     config.GetForwardQueueLease(),
+		retention: config.GetStorageRetention(),
+		pushed:    make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+	// a compaction interrupted by a crash leaves its copy behind
+	os.Remove(s.compactPath())
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	if err := s.maintain(); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	if d := config.GetStorageCompactionInterval(); d > 0 {
+		go s.maintainEvery(d)
+	}
+	return s, nil
+}
+
+func (s *store) open() error {
+	db, err := bbolt.Open(s.path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range [][]byte{deliveriesBucket, queueBucket, deadLettersBucket, routingBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	s.db = db
+	return nil
+}
+
+// ready opens the file again if reopening it after compacting failed
+func (s *store) ready() error {
+	s.lock.RLock()
+	err := s.reopenErr
+	s.lock.RUnlock()
+	if err == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.reopenErr != nil {
+		s.reopenErr = s.open()
+	}
+	return s.reopenErr
+}
+
+// view runs fn in a read-only transaction
+func (s *store) view(fn func(tx *bbolt.Tx) error) error {
+	if err := s.ready(); err != nil {
+		return err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.db.View(fn)
+}
+
+// update runs fn in a read-write transaction, committed
+// to disk when update returns
+func (s *store) update(fn func(tx *bbolt.Tx) error) error {
+	if err := s.ready(); err != nil {
+		return err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.db.Update(fn)
+}
+
+func getRecord(tx *bbolt.Tx, id string) (*record, error) {
+	v := tx.Bucket(deliveriesBucket).Get([]byte(id))
+	if v == nil {
+		return nil, storage.ErrNotFound
+	}
+	var r record
+	if err := json.Unmarshal(v, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func putRecord(tx *bbolt.Tx, r *record) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(deliveriesBucket).Put([]byte(r.ID), v)
+}
+
+// delivery returns the delivery of the record
+func (r *record) delivery() (*forward.Delivery, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &forward.Delivery{
+		ID:         r.ID,
+		Method:     r.Method,
+		URL:        u,
+		Header:     r.Header,
+		Body:       r.Body,
+		RemoteAddr: r.RemoteAddr,
+	}, nil
+}
+
+// newRecord returns the record of a delivery not recorded yet
+func newRecord(d *forward.Delivery) *record {
+	return &record{
+		ID:         d.ID,
+		Method:     d.Method,
+		URL:        d.URL.String(),
+		Header:     d.Header,
+		Body:       d.Body,
+		RemoteAddr: d.RemoteAddr,
+		Created:    time.Now(),
+	}
+}
+
+func (s *store) Save(r *storage.Record) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		rec, err := getRecord(tx, r.Delivery.ID)
+		if err == storage.ErrNotFound {
+			rec, err = newRecord(r.Delivery), nil
+		}
+		if err != nil {
+			return err
+		}
+		rec.Provider = r.Provider
+		rec.Verified = r.Verified
+		rec.Targets = storage.RedactTargets(r.Targets)
+		rec.Policy = r.Policy
+		rec.Status = r.Status
+		rec.Error = r.Error
+		rec.Attempts = r.Attempts
+		rec.Updated = time.Now()
+		return putRecord(tx, rec)
+	})
+}
+
+func (s *store) Get(id string) (*storage.Record, error) {
+	var res *storage.Record
+	err := s.view(func(tx *bbolt.Tx) error {
+		r, err := getRecord(tx, id)
+		if err != nil {
+			return err
+		}
+		d, err := r.delivery()
+		if err != nil {
+			return err
+		}
+		res = &storage.Record{
+			Delivery: d,
+			Provider: r.Provider,
+			Verified: r.Verified,
+			Targets:  r.Targets,
+			Policy:   r.Policy,
+			Status:   r.Status,
+			Error:    r.Error,
+			Attempts: r.Attempts,
+			Created:  r.Created,
+			Updated:  r.Updated,
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Push records the delivery as queued
+func (s *store) Push(j *forward.Job) error {
+	err := s.update(func(tx *bbolt.Tx) error {
+		q := tx.Bucket(queueBucket)
+		if s.size > 0 && q.Stats().KeyN >= s.size {
+			return forward.ErrQueueFull
+		}
+		rec, err := getRecord(tx, j.Delivery.ID)
+		if err == storage.ErrNotFound {
+			rec, err = newRecord(j.Delivery), nil
+			rec.Verified = true
+		}
+		if err != nil {
+			return err
+		}
+		seq, err := q.NextSequence()
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(&queued{ID: rec.ID, NotBefore: j.NotBefore, OrderKey: j.OrderKey()})
+		if err != nil {
+			return err
+		}
+		rec.QueueKey = make([]byte, 8)
+		binary.BigEndian.PutUint64(rec.QueueKey, seq)
+		if err := q.Put(rec.QueueKey, v); err != nil {
+			return err
+		}
+		rec.Targets = storage.RedactTargets(j.Targets)
+		rec.Status = storage.StatusQueued
+		rec.Updated = time.Now()
+		return putRecord(tx, rec)
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case s.pushed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pop waits for a queued delivery due, or one whose worker's lease
+// expired, and claims it. Ordered deliveries are claimed once the
+// earlier ones of their key are done. The queue is closed once the store is, a
+// file which could not be reopened after compacting is an error.
+func (s *store) Pop() (*forward.Job, error) {
+	for {
+		j, err := s.claim()
+		if err == bbolt.ErrDatabaseNotOpen {
+			select {
+			case <-s.closed:
+				return nil, forward.ErrQueueClosed
+			default:
+			}
+		}
+		if err != nil || j != nil {
+			return j, err
+		}
+		select {
+		case <-s.closed:
+			return nil, forward.ErrQueueClosed
+		case <-s.pushed:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (s *store) claim() (*forward.Job, error) {
+	var j *forward.Job
+	err := s.update(func(tx *bbolt.Tx) error {
+		now := time.Now()
+		// blocked are the order keys of the deliveries not claimable
+		blocked := map[string]bool{}
+		c := tx.Bucket(queueBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var q queued
+			if err := json.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			if blocked[q.OrderKey] {
+				continue
+			}
+			if q.LockedUntil.After(now) || q.NotBefore.After(now) {
+				if q.OrderKey != "" {
+					blocked[q.OrderKey] = true
+				}
+				continue
+			}
+			q.LockedUntil = now.Add(s.lease)
+			v, err := json.Marshal(&q)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(queueBucket).Put(k, v); err != nil {
+				return err
+			}
+			rec, err := getRecord(tx, q.ID)
+			if err != nil {
+				return err
+			}
+			rec.Status = storage.StatusProcessing
+			rec.Updated = now
+			if err := putRecord(tx, rec); err != nil {
+				return err
+			}
+			d, err := rec.delivery()
+			if err != nil {
+				return err
+			}
+			j = &forward.Job{Delivery: d, Targets: rec.Targets}
+			return nil
+		}
+		return nil
+	})
+	return j, err
+}
+
+// Renew extends the lease of the claimed delivery
+func (s *store) Renew(j *forward.Job) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		rec, err := getRecord(tx, j.Delivery.ID)
+		if err != nil || rec.QueueKey == nil {
+			return err
+		}
+		q := tx.Bucket(queueBucket)
+		var v queued
+		if err := json.Unmarshal(q.Get(rec.QueueKey), &v); err != nil {
+			return err
+		}
+		v.LockedUntil = time.Now().Add(s.lease)
+		b, err := json.Marshal(&v)
+		if err != nil {
+			return err
+		}
+		return q.Put(rec.QueueKey, b)
+	})
+}
+
+// Done records the status, appends the attempts of the
+// delivery and removes it from the queue
+func (s *store) Done(j *forward.Job, res *forward.Result) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		rec, err := getRecord(tx, j.Delivery.ID)
+		if err != nil {
+			return err
+		}
+		if rec.QueueKey != nil {
+			if err := tx.Bucket(queueBucket).Delete(rec.QueueKey); err != nil {
+				return err
+			}
+		}
+		rec.QueueKey = nil
+		rec.Status = storage.StatusOf(res)
+		rec.Attempts = append(rec.Attempts, j.Delivery.Attempts()...)
+		rec.Updated = time.Now()
+		return putRecord(tx, rec)
+	})
+}
+
+// Len returns the number of deliveries not claimed by a worker
+func (s *store) Len() (int, error) {
+	n := 0
+	err := s.view(func(tx *bbolt.Tx) error {
+		now := time.Now()
+		return tx.Bucket(queueBucket).ForEach(func(k, v []byte) error {
+			var q queued
+			if err := json.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			if !q.LockedUntil.After(now) {
+				n++
+			}
+			return nil
+		})
+	})
+	return n, err
+}
+
+func (s *store) Close() error {
+	close(s.closed)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.db.Close()
+}
+
+func (s *store) maintainEvery(d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			if err := s.maintain(); err != nil {
+				log.Error(nil, map[string]interface{}{
+					"path": s.path,
+					"err":  err,
+				}, "failed to compact the storage")
+			}
+		}
+	}
+}
+
+// maintain prunes the deliveries forwarded longer than the retention
+// ago and compacts the file once enough of it is free
+func (s *store) maintain() error {
+	if err := s.prune(); err != nil {
+		return err
+	}
+	var free float64
+	err := s.view(func(tx *bbolt.Tx) error {
+		if tx.Size() < compactMinSize {
+			return nil
+		}
+		stats := s.db.Stats()
+		pages := float64(tx.Size()) / float64(s.db.Info().PageSize)
+		free = float64(stats.FreePageN+stats.PendingPageN) / pages
+		return nil
+	})
+	if err != nil || free < compactRatio {
+		return err
+	}
+	return s.compact()
+}
+
+func (s *store) prune() error {
+	if s.retention <= 0 {
+		return nil
+	}
+	before := time.Now().Add(-s.retention)
+	return s.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(deliveriesBucket)
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var r record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if r.QueueKey == nil && r.Updated.Before(before) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *store) compactPath() string {
+	return s.path + ".compact"
+}
+
+// compact copies the live data to a new file replacing the current
+// one. The rename is atomic, a crash keeps either file intact.
+func (s *store) compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	dst, err := bbolt.Open(s.compactPath(), 0600, nil)
+	if err != nil {
+		return err
+	}
+	if err := bbolt.Compact(dst, s.db, 0); err != nil {
+		dst.Close()
+		os.Remove(s.compactPath())
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(s.compactPath())
+		return err
+	}
+	if err := s.db.Close(); err != nil {
+		os.Remove(s.compactPath())
+		// the file may be closed anyway
+		return s.reopen(err)
+	}
+	if err := os.Rename(s.compactPath(), s.path); err != nil {
+		// keep going with the current file
+		return s.reopen(err)
+	}
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return s.reopen(nil)
+}
+
+// reopen opens the file closed for compacting, returning cause if it
+// is opened. Otherwise transactions fail until it is opened again.
+func (s *store) reopen(cause error) error {
+	if err := s.open(); err != nil {
+		s.reopenErr = err
+		return err
+	}
+	return cause
+}
+
+// routingTableKey is the key of the routing table document
+var routingTableKey = []byte("table")
+
+func (s *store) RoutingTable() ([]byte, error) {
+	var doc []byte
+	err := s.view(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(routingBucket).Get(routingTableKey)
+		if v == nil {
+			return routing.ErrNoTable
+		}
+		// v is only valid during the transaction
+		doc = append([]byte(nil), v...)
+		return nil
+	})
+	return doc, err
+}
+
+func (s *store) SaveRoutingTable(doc []byte) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(routingBucket).Put(routingTableKey, doc)
+	})
+}
@@ -0,0 +1,385 @@
+package bolt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-webhook/deadletter"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+	"github.com/fabric8-services/fabric8-webhook/storage"
+	bbolt "go.etcd.io/bbolt"
+)
+
+type testConfiguration struct {
+	path      string
+	size      int
+	lease     time.Duration
+	retention time.Duration
+}
+
+func (c *testConfiguration) GetStorageBoltPath() string                  { return c.path }
+func (c *testConfiguration) GetStorageRetention() time.Duration          { return c.retention }
+func (c *testConfiguration) GetStorageCompactionInterval() time.Duration { return 0 }
+func (c *testConfiguration) GetForwardQueueSize() int                    { return c.size }
+func (c *testConfiguration) GetForwardQueueLease() time.Duration         { return c.lease }
+
+func newConfiguration(t *testing.T) (*testConfiguration, func()) {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testConfiguration{
+		path:      filepath.Join(dir, "webhook.db"),
+		lease:     time.Minute,
+		retention: time.Hour,
+	}, func() { os.RemoveAll(dir) }
+}
+
+func newStore(t *testing.T, config *testConfiguration) *store {
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*store)
+}
+
+func newDelivery() *forward.Delivery {
+	req := httptest.NewRequest("POST", "/api/webhook?a=1", nil)
+	req.Header.Set("X-GitHub-Event", "push")
+	return forward.NewDelivery(req, []byte(`{"ref":"refs/heads/master"}`))
+}
+
+func Test_store_Save(t *testing.T) {
+	config, cleanup := newConfiguration(t)
+	defer cleanup()
+	s := newStore(t, config)
+	defer s.Close()
+
+	d := newDelivery()
+	r := &storage.Record{Delivery: d, Provider: "github", Verified: true,
+		Status: storage.StatusUnrouted, Error: "No route matches the repository"}
+	if err := s.Save(r); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if got, err := s.Get(d.ID); err != nil || got.Status != storage.StatusUnrouted || got.Error != r.Error {
+		t.Errorf("Get() unrouted = %+v, %v", got, err)
+	}
+	r.Error = ""
+	r.Targets = []forward.Target{{URL: "http://jenkins", Credentials: forward.Credentials{User: "admin", APIToken: "secret"}}}
+	r.Status = storage.StatusDelivered
+	if err := s.Save(r); err != nil {
+		t.Fatalf("Save() again error = %v", err)
+	}
+	got, err := s.Get(d.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Provider != "github" || got.Status != storage.StatusDelivered || len(got.Targets) != 1 ||
+		got.Delivery.URL.String() != d.URL.String() || string(got.Delivery.Body) != string(d.Body) {
+		t.Errorf("Get() = %+v", got)
+	}
+	if got.Error != "" || got.Targets[0].Credentials.APIToken != "" {
+		t.Errorf("Get() targets = %+v, want them stored without secrets", got.Targets)
+	}
+	if _, err := s.Get(forward.NewID()); err != storage.ErrNotFound {
+		t.Errorf("Get() unknown error = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func Test_store_Queue(t *testing.T) {
+	config, cleanup := newConfiguration(t)
+	defer cleanup()
+	config.size = 2
+	s := newStore(t, config)
+
+	first, second := newDelivery(), newDelivery()
+	for _, d := range []*forward.Delivery{first, second} {
+		if err := s.Push(&forward.Job{Delivery: d, Targets: []forward.Target{{URL: "http://jenkins"}}}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	}
+	if err := s.Push(&forward.Job{Delivery: newDelivery()}); err != forward.ErrQueueFull {
+		t.Errorf("Push() on full queue error = %v, want %v", err, forward.ErrQueueFull)
+	}
+
+	a, err := s.Pop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Delivery.ID != first.ID || len(a.Targets) != 1 {
+		t.Errorf("Pop() = %v, want %s", a.Delivery.ID, first.ID)
+	}
+	if n, _ := s.Len(); n != 1 {
+		t.Errorf("Len() = %d, want 1", n)
+	}
+	res := &forward.Result{Outcomes: []*forward.Outcome{{Response: &forward.Response{StatusCode: http.StatusOK}}}}
+	if err := s.Done(a, res); err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+	if got, _ := s.Get(first.ID); got.Status != storage.StatusDelivered {
+		t.Errorf("status after Done() = %v, want %v", got.Status, storage.StatusDelivered)
+	}
+
+	// the second delivery is claimed, then the process crashes
+	// and the lease expires
+	s.lease = 0
+	if _, err := s.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s = newStore(t, config)
+	defer s.Close()
+	j, err := s.claim()
+	if err != nil || j == nil || j.Delivery.ID != second.ID {
+		t.Errorf("claim() after restart = %v, %v, want %s", j, err, second.ID)
+	}
+}
+
+func Test_store_PopClosed(t *testing.T) {
+	config, cleanup := newConfiguration(t)
+	defer cleanup()
+	s := newStore(t, config)
+	done := make(chan error)
+	go func() {
+		_, err := s.Pop()
+		done <- err
+	}()
+	s.Close()
+	if err := <-done; err != forward.ErrQueueClosed {
+		t.Errorf("Pop() after Close() error = %v, want %v", err, forward.ErrQueueClosed)
+	}
+}
+
+func Test_store_maintain(t *testing.T) {
+	config, cleanup := newConfiguration(t)
+	defer cleanup()
+	s := newStore(t, config)
+	defer s.Close()
+
+	old := newDelivery()
+	if err := s.Save(&storage.Record{Delivery: old, Status: storage.StatusDelivered}); err != nil {
+		t.Fatal(err)
+	}
+	// fill the file, then free most of it
+	body := make([]byte, 64*1024)
+	for i := 0; i < 64; i++ {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/%d", i), nil)
+		d := forward.NewDelivery(req, body)
+		if err := s.Save(&storage.Record{Delivery: d, Status: storage.StatusDelivered}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queued := newDelivery()
+	if err := s.Push(&forward.Job{Delivery: queued}); err != nil {
+		t.Fatal(err)
+	}
+	before := fileSize(t, config.path)
+	s.retention = time.Nanosecond
+	if err := s.maintain(); err != nil {
+		t.Fatalf("maintain() error = %v", err)
+	}
+	if after := fileSize(t, config.path); after >= before {
+		t.Errorf("file size after maintain() = %d, want less than %d", after, before)
+	}
+	if _, err := s.Get(old.ID); err != storage.ErrNotFound {
+		t.Errorf("Get() pruned delivery error = %v, want %v", err, storage.ErrNotFound)
+	}
+	if _, err := s.Get(queued.ID); err != nil {
+		t.Errorf("Get() queued delivery error = %v, queued deliveries are never pruned", err)
+	}
+	// the store is usable after compaction
+	err := s.view(func(tx *bbolt.Tx) error {
+		if tx.Bucket(queueBucket).Stats().KeyN != 1 {
+			t.Errorf("queue after compaction has %d deliveries, want 1", tx.Bucket(queueBucket).Stats().KeyN)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_store_reopen(t *testing.T) {
+	config, cleanup := newConfiguration(t)
+	defer cleanup()
+	s := newStore(t, config)
+	defer s.Close()
+
+	// the file can not be opened again after compacting
+	s.db.Close()
+	s.path = filepath.Join(config.path, "missing")
+	if err := s.reopen(nil); err == nil {
+		t.Fatal("reopen() error = nil, want an error")
+	}
+	if _, err := s.Pop(); err == nil || err == forward.ErrQueueClosed {
+		t.Errorf("Pop() after failed reopen error = %v, want the reopen error", err)
+	}
+
+	s.path = config.path
+	if err := s.Push(&forward.Job{Delivery: newDelivery()}); err != nil {
+		t.Fatalf("Push() once the file can be opened error = %v", err)
+	}
+	if j, err := s.Pop(); err != nil || j == nil {
+		t.Errorf("Pop() once the file can be opened = %v, %v", j, err)
+	}
+}
+
+func Test_store_NotBefore(t *testing.T) {
+	config, cleanup := newConfiguration(t)
+	defer cleanup()
+	s := newStore(t, config)
+	defer s.Close()
+	later, due := newDelivery(), newDelivery()
+	if err := s.Push(&forward.Job{Delivery: later, NotBefore: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := s.claim(); err != nil || j != nil {
+		t.Errorf("claim() before NotBefore = %v, %v, want none", j, err)
+	}
+	if err := s.Push(&forward.Job{Delivery: due}); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := s.claim(); err != nil || j == nil || j.Delivery.ID != due.ID {
+		t.Errorf("claim() = %v, %v, want %s", j, err, due.ID)
+	}
+	// superseded while waiting for its debounce window
+	if err := s.Done(&forward.Job{Delivery: later}, &forward.Result{CoalescedInto: due.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Get(later.ID); got.Status != storage.StatusCoalesced {
+		t.Errorf("status after Done() = %v, want %v", got.Status, storage.StatusCoalesced)
+	}
+	if n, _ := s.Len(); n != 0 {
+		t.Errorf("Len() = %d, want 0", n)
+	}
+}
+
+func Test_store_Order(t *testing.T) {
+	config, cleanup := newConfiguration(t)
+	defer cleanup()
+	s := newStore(t, config)
+	defer s.Close()
+	ordered := func(repo string) []forward.Target {
+		return []forward.Target{{URL: "http://jenkins", Ordering: forward.OrderRepository,
+			Event: forward.Event{GitURL: "https://github.com/org/" + repo}}}
+	}
+	push, del, other, unordered := newDelivery(), newDelivery(), newDelivery(), newDelivery()
+	for _, j := range []*forward.Job{
+		{Delivery: push, Targets: ordered("app")},
+		{Delivery: del, Targets: ordered("app")},
+		{Delivery: other, Targets: ordered("lib")},
+		{Delivery: unordered},
+	} {
+		if err := s.Push(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the delete waits for the push, whichever replica claimed it
+	for _, want := range []*forward.Delivery{push, other, unordered, nil} {
+		j, err := s.claim()
+		if err != nil || (j == nil) != (want == nil) || j != nil && j.Delivery.ID != want.ID {
+			t.Fatalf("claim() = %v, %v, want %v", j, err, want)
+		}
+	}
+	if err := s.Done(&forward.Job{Delivery: push}, &forward.Result{}); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := s.claim(); err != nil || j == nil || j.Delivery.ID != del.ID {
+		t.Errorf("claim() once the push is done = %v, %v, want %s", j, err, del.ID)
+	}
+}
+
+func Test_store_Renew(t *testing.T) {
+	config, cleanup := newConfiguration(t)
+	defer cleanup()
+	s := newStore(t, config)
+	defer s.Close()
+
+	s.lease = 0
+	if err := s.Push(&forward.Job{Delivery: newDelivery()}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := s.Pop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the delivery waits for the previous ordered ones
+	s.lease = time.Hour
+	if err := s.Renew(j); err != nil {
+		t.Fatalf("Renew() error = %v", err)
+	}
+	if got, err := s.claim(); err != nil || got != nil {
+		t.Errorf("claim() of renewed delivery = %v, %v, want none", got, err)
+	}
+}
+
+func Test_deadLetters(t *testing.T) {
+	config, cleanup := newConfiguration(t)
+	defer cleanup()
+	s := newStore(t, config)
+	defer s.Close()
+	dl := s.DeadLetters()
+
+	d := newDelivery()
+	failed := &forward.Outcome{Target: "http://a", Response: &forward.Response{StatusCode: 503}}
+	for _, target := range []string{"http://a", "http://b"} {
+		if err := dl.Add(d, forward.Target{URL: target}, failed); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	all, err := dl.List("")
+	if err != nil || len(all) != 2 || all[0].Target.URL != "http://a" {
+		t.Fatalf("List() = %v, %v", all, err)
+	}
+	b, err := dl.List("http://b")
+	if err != nil || len(b) != 1 || b[0].Delivery.ID != d.ID || b[0].Delivery.Header.Get("X-GitHub-Event") != "push" {
+		t.Fatalf("List(http://b) = %v, %v", b, err)
+	}
+	if _, err := dl.Get(b[0].ID); err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+	if err := dl.Remove(b[0].ID); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if _, err := dl.Get(b[0].ID); err != deadletter.ErrNotFound {
+		t.Errorf("Get() after Remove() error = %v, want %v", err, deadletter.ErrNotFound)
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+func Test_store_RoutingTable(t *testing.T) {
+	config, cleanup := newConfiguration(t)
+	defer cleanup()
+	s := newStore(t, config)
+	if _, err := s.RoutingTable(); err != routing.ErrNoTable {
+		t.Errorf("RoutingTable() error = %v, want %v", err, routing.ErrNoTable)
+	}
+	doc := []byte("default: http://default\n")
+	if err := s.SaveRoutingTable(doc); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// the table is kept across restarts
+	s = newStore(t, config)
+	defer s.Close()
+	got, err := s.RoutingTable()
+	if err != nil || string(got) != string(doc) {
+		t.Errorf("RoutingTable() = %s, %v, want %s", got, err, doc)
+	}
+}
@@ -0,0 +1,139 @@
+package bolt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/fabric8-services/fabric8-webhook/deadletter"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// letter is a stored dead letter
+type letter struct {
+	ID         string
+	DeliveryID string
+	Method     string
+	URL        string
+	Header     http.Header
+	Body       []byte
+	RemoteAddr string
+	Target     forward.Target
+	Reason     string
+	Attempts   []forward.Attempt
+	Created    time.Time
+}
+
+func (l *letter) deadLetter() (*deadletter.Letter, error) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &deadletter.Letter{
+		ID: l.ID,
+		Delivery: &forward.Delivery{
+			ID:         l.DeliveryID,
+			Method:     l.Method,
+			URL:        u,
+			Header:     l.Header,
+			Body:       l.Body,
+			RemoteAddr: l.RemoteAddr,
+		},
+		Target:   l.Target,
+		Reason:   l.Reason,
+		Attempts: l.Attempts,
+		Created:  l.Created,
+	}, nil
+}
+
+// deadLetters keeps the dead letters in the bolt file
+// until they are replayed or discarded
+type deadLetters struct {
+	*store
+}
+
+func (s *store) DeadLetters() deadletter.Store {
+	return &deadLetters{store: s}
+}
+
+func (s *deadLetters) Add(d *forward.Delivery, t forward.Target, o *forward.Outcome) error {
+	l := deadletter.NewLetter(d, t, o)
+	v, err := json.Marshal(&letter{
+		ID:         l.ID,
+		DeliveryID: l.Delivery.ID,
+		Method:     l.Delivery.Method,
+		URL:        l.Delivery.URL.String(),
+		Header:     l.Delivery.Header,
+		Body:       l.Delivery.Body,
+		RemoteAddr: l.Delivery.RemoteAddr,
+		Target:     l.Target,
+		Reason:     l.Reason,
+		Attempts:   l.Attempts,
+		Created:    l.Created,
+	})
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(deadLettersBucket).Put([]byte(l.ID), v)
+	})
+}
+
+func (s *deadLetters) List(target string) ([]*deadletter.Letter, error) {
+	letters := []*deadletter.Letter{}
+	err := s.view(func(tx *bbolt.Tx) error {
+		return tx.Bucket(deadLettersBucket).ForEach(func(k, v []byte) error {
+			var l letter
+			if err := json.Unmarshal(v, &l); err != nil {
+				return err
+			}
+			if target != "" && l.Target.URL != target {
+				return nil
+			}
+			dl, err := l.deadLetter()
+			if err != nil {
+				return err
+			}
+			letters = append(letters, dl)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].Created.Before(letters[j].Created)
+	})
+	return letters, nil
+}
+
+func (s *deadLetters) Get(id string) (*deadletter.Letter, error) {
+	var res *deadletter.Letter
+	err := s.view(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(deadLettersBucket).Get([]byte(id))
+		if v == nil {
+			return deadletter.ErrNotFound
+		}
+		var l letter
+		if err := json.Unmarshal(v, &l); err != nil {
+			return err
+		}
+		var err error
+		res, err = l.deadLetter()
+		return err
+	})
+	return res, err
+}
+
+func (s *deadLetters) Remove(id string) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(deadLettersBucket)
+		if b.Get([]byte(id)) == nil {
+			return deadletter.ErrNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
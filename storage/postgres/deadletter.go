@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/url"
+
+	"github.com/fabric8-services/fabric8-webhook/deadletter"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+)
+
+// deadLetters keeps the dead letters in postgres until
+// they are replayed or discarded
+type deadLetters struct {
+	*store
+}
+
+func (s *store) DeadLetters() deadletter.Store {
+	return &deadLetters{store: s}
+}
+
+func (s *deadLetters) Add(d *forward.Delivery, t forward.Target, o *forward.Outcome) error {
+	l := deadletter.NewLetter(d, t, o)
+	headers, err := json.Marshal(l.Delivery.Header)
+	if err != nil {
+		return err
+	}
+	target, err := json.Marshal(l.Target)
+	if err != nil {
+		return err
+	}
+	attempts, err := json.Marshal(nonNilAttempts(l.Attempts))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err = s.db.ExecContext(ctx, `INSERT INTO dead_letters
+		(id, delivery_id, method, url, headers, body, remote_addr,
+		target, target_url, reason, attempts, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		l.ID, l.Delivery.ID, l.Delivery.Method, l.Delivery.URL.String(),
+		headers, l.Delivery.Body, l.Delivery.RemoteAddr,
+		target, l.Target.URL, l.Reason, attempts, l.Created)
+	return err
+}
+
+const selectLetters = `SELECT id, delivery_id, method, url, headers, body,
+	remote_addr, target, reason, attempts, created_at FROM dead_letters`
+
+func (s *deadLetters) List(target string) ([]*deadletter.Letter, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, selectLetters+`
+		WHERE $1 = '' OR target_url = $1 ORDER BY created_at`, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	letters := []*deadletter.Letter{}
+	for rows.Next() {
+		l, err := scanLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, l)
+	}
+	return letters, rows.Err()
+}
+
+func (s *deadLetters) Get(id string) (*deadletter.Letter, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	l, err := scanLetter(s.db.QueryRowContext(ctx, selectLetters+` WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, deadletter.ErrNotFound
+	}
+	return l, err
+}
+
+func (s *deadLetters) Remove(id string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM dead_letters WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return deadletter.ErrNotFound
+	}
+	return nil
+}
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanLetter(row scanner) (*deadletter.Letter, error) {
+	var (
+		l                         deadletter.Letter
+		d                         forward.Delivery
+		rawURL                    string
+		headers, target, attempts []byte
+	)
+	err := row.Scan(&l.ID, &d.ID, &d.Method, &rawURL, &headers, &d.Body,
+		&d.RemoteAddr, &target, &l.Reason, &attempts, &l.Created)
+	if err != nil {
+		return nil, err
+	}
+	if d.URL, err = url.Parse(rawURL); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(headers, &d.Header); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(target, &l.Target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(attempts, &l.Attempts); err != nil {
+		return nil, err
+	}
+	l.Delivery = d.Copy()
+	return &l, nil
+}
@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	errs "github.com/pkg/errors"
+)
+
+// migrationLock is the advisory lock serializing migrations
+// of instances starting at the same time
+const migrationLock = 4242
+
+// migrations are applied in order, each one once. Never change a
+// released migration, append a new one instead.
+var migrations = []string{
+	// 0: deliveries with their forwarding state
+	`CREATE TABLE deliveries (
+		id uuid PRIMARY KEY,
+		provider text NOT NULL DEFAULT '',
+		method text NOT NULL,
+		url text NOT NULL,
+		headers jsonb NOT NULL,
+		body bytea NOT NULL,
+		remote_addr text NOT NULL,
+		verified boolean NOT NULL,
+		targets jsonb NOT NULL DEFAULT '[]',
+		policy text NOT NULL DEFAULT '',
+		status text NOT NULL,
+		attempts jsonb NOT NULL DEFAULT '[]',
+		locked_until timestamptz,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		updated_at timestamptz NOT NULL DEFAULT now()
+	);
+	CREATE INDEX deliveries_queue_idx ON deliveries (created_at)
+		WHERE status IN ('queued', 'processing');`,
+	// 1: dead letters with their original request
+	`CREATE TABLE dead_letters (
+		id uuid PRIMARY KEY,
+		delivery_id uuid NOT NULL,
+		method text NOT NULL,
+		url text NOT NULL,
+		headers jsonb NOT NULL,
+		body bytea NOT NULL,
+		remote_addr text NOT NULL,
+		target jsonb NOT NULL,
+		target_url text NOT NULL,
+		reason text NOT NULL,
+		attempts jsonb NOT NULL,
+		created_at timestamptz NOT NULL
+	);
+	CREATE INDEX dead_letters_created_idx ON dead_letters (created_at);`,
+	// 2: the routing table document, a single row
+	`CREATE TABLE routing_table (
+		id boolean PRIMARY KEY DEFAULT true CHECK (id),
+		document bytea NOT NULL,
+		updated_at timestamptz NOT NULL DEFAULT now()
+	);`,
+	// 3: why deliveries could not be routed, and the secrets of
+	// the targets stored before they were redacted removed
+	`ALTER TABLE deliveries ADD COLUMN error text NOT NULL DEFAULT '';
+	UPDATE deliveries SET targets = (SELECT coalesce(jsonb_agg(t - 'Token'
+		#- '{Credentials,APIToken}' #- '{Credentials,Token}'
+		#- '{BuildConfig,Secret}' ORDER BY i), '[]')
+		FROM jsonb_array_elements(targets) WITH ORDINALITY e(t, i));
+	UPDATE dead_letters SET target = target - 'Token'
+		#- '{Credentials,APIToken}' #- '{Credentials,Token}'
+		#- '{BuildConfig,Secret}';`,
+	// 4: when queued deliveries may be claimed, e.g. once
+	// their debounce window closed
+	`ALTER TABLE deliveries ADD COLUMN not_before timestamptz;`,
+	// 5: the key of queued deliveries claimed one at a time, in order
+	`ALTER TABLE deliveries ADD COLUMN order_key text NOT NULL DEFAULT '';
+	CREATE INDEX deliveries_order_idx ON deliveries (order_key, created_at, id)
+		WHERE status IN ('queued', 'processing');`,
+}
+
+// migrate applies the migrations newer than the schema version
+func migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+	var current int
+	err = tx.QueryRowContext(ctx,
+		`SELECT coalesce(max(version), -1) FROM schema_version`).Scan(&current)
+	if err != nil {
+		return err
+	}
+	for v := current + 1; v < len(migrations); v++ {
+		if _, err := tx.ExecContext(ctx, migrations[v]); err != nil {
+			return errs.Wrapf(err, "failed to apply migration %d", v)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO schema_version (version) VALUES ($1)`, v); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
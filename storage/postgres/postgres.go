@@ -0,0 +1,324 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+	"github.com/fabric8-services/fabric8-webhook/storage"
+	// register the postgres driver
+	_ "github.com/lib/pq"
+)
+
+// pollInterval is how often an idle worker looks for queued deliveries
+const pollInterval = time.Second
+
+// connectAttempts is the number of attempts to connect on startup
+const connectAttempts = 10
+
+// storeConfiguration the Configuration needed by the postgres store
+type storeConfiguration interface {
+	GetPostgresConfigString() string
+	GetPostgresConnectionMaxIdle() int
+	GetPostgresConnectionMaxOpen() int
+	GetPostgresConnectionRetrySleep() time.Duration
+	GetPostgresTransactionTimeout() time.Duration
+	GetForwardQueueSize() int
+	GetForwardQueueLease() time.Duration
+}
+
+type store struct {
+	db *sql.DB
+	// timeout of every statement
+	timeout time.Duration
+	// size is the maximum number of queued deliveries
+	size int
+	// lease is how long a worker owns a delivery it popped
+	lease  time.Duration
+	closed chan struct{}
+}
+
+// New connects to postgres, migrates the schema
+// and returns a storage.Store instance
+func New(config storeConfiguration) (storage.Store, error) {
+	db, err := sql.Open("postgres", config.GetPostgresConfigString())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxIdleConns(config.GetPostgresConnectionMaxIdle())
+	db.SetMaxOpenConns(config.GetPostgresConnectionMaxOpen())
+	for i := 1; ; i++ {
+		if err = db.Ping(); err == nil {
+			break
+		}
+		if i == connectAttempts {
+			db.Close()
+			return nil, err
+		}
+		log.Warn(nil, map[string]interface{}{
+			"attempt": i,
+			"err":     err,
+		}, "failed to connect to postgres, retrying")
+		time.Sleep(config.GetPostgresConnectionRetrySleep())
+	}
+
+	s := &store{
+		db:      db,
+		timeout: config.GetPostgresTransactionTimeout(),
+		size:    config.GetForwardQueueSize(),
+		lease:   config.GetForwardQueueLease(),
+		closed:  make(chan struct{}),
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *store) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeout)
+}
+
+func (s *store) Save(r *storage.Record) error {
+	headers, err := json.Marshal(r.Delivery.Header)
+	if err != nil {
+		return err
+	}
+	targets, err := json.Marshal(storage.RedactTargets(r.Targets))
+	if err != nil {
+		return err
+	}
+	attempts, err := json.Marshal(nonNilAttempts(r.Attempts))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err = s.db.ExecContext(ctx, `INSERT INTO deliveries
+		(id, provider, method, url, headers, body, remote_addr,
+		verified, targets, policy, status, error, attempts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (id) DO UPDATE SET
+		provider = EXCLUDED.provider, verified = EXCLUDED.verified,
+		targets = EXCLUDED.targets, policy = EXCLUDED.policy,
+		status = EXCLUDED.status, error = EXCLUDED.error,
+		attempts = EXCLUDED.attempts, updated_at = now()`,
+		r.Delivery.ID, r.Provider, r.Delivery.Method, r.Delivery.URL.String(),
+		headers, r.Delivery.Body, r.Delivery.RemoteAddr,
+		r.Verified, targets, string(r.Policy), string(r.Status), r.Error, attempts)
+	return err
+}
+
+func (s *store) Get(id string) (*storage.Record, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	var (
+		r                          storage.Record
+		rawURL                     string
+		headers, targets, attempts []byte
+		policy, status             string
+		d                          forward.Delivery
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT id, provider, method, url,
+		headers, body, remote_addr, verified, targets, policy, status,
+		error, attempts, created_at, updated_at FROM deliveries WHERE id = $1`, id).Scan(
+		&d.ID, &r.Provider, &d.Method, &rawURL, &headers, &d.Body, &d.RemoteAddr,
+		&r.Verified, &targets, &policy, &status, &r.Error, &attempts, &r.Created, &r.Updated)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.URL, err = url.Parse(rawURL); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(headers, &d.Header); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(targets, &r.Targets); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(attempts, &r.Attempts); err != nil {
+		return nil, err
+	}
+	r.Delivery = d.Copy()
+	r.Policy = forward.Policy(policy)
+	r.Status = storage.Status(status)
+	return &r, nil
+}
+
+// Push records the delivery as queued
+func (s *store) Push(j *forward.Job) error {
+	n, err := s.Len()
+	if err != nil {
+		return err
+	}
+	if s.size > 0 && n >= s.size {
+		return forward.ErrQueueFull
+	}
+	headers, err := json.Marshal(j.Delivery.Header)
+	if err != nil {
+		return err
+	}
+	targets, err := json.Marshal(storage.RedactTargets(j.Targets))
+	if err != nil {
+		return err
+	}
+	var notBefore *time.Time
+	if !j.NotBefore.IsZero() {
+		notBefore = &j.NotBefore
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err = s.db.ExecContext(ctx, `INSERT INTO deliveries
+		(id, method, url, headers, body, remote_addr, verified, targets, status, not_before, order_key)
+		VALUES ($1, $2, $3, $4, $5, $6, true, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET
+		targets = EXCLUDED.targets, status = EXCLUDED.status,
+		not_before = EXCLUDED.not_before, order_key = EXCLUDED.order_key,
+		updated_at = now()`,
+		j.Delivery.ID, j.Delivery.Method, j.Delivery.URL.String(), headers,
+		j.Delivery.Body, j.Delivery.RemoteAddr, targets, string(storage.StatusQueued), notBefore,
+		j.OrderKey())
+	return err
+}
+
+// Pop waits for a queued delivery due, or one whose worker's lease
+// expired, and claims it. Concurrent workers skip each other's
+// deliveries instead of waiting for them. Ordered deliveries are
+// claimed once the earlier ones of their key are done, whichever
+// replica claimed those.
+func (s *store) Pop() (*forward.Job, error) {
+	for {
+		j, err := s.claim()
+		if err != nil || j != nil {
+			return j, err
+		}
+		select {
+		case <-s.closed:
+			return nil, forward.ErrQueueClosed
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (s *store) claim() (*forward.Job, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	var (
+		rawURL           string
+		headers, targets []byte
+		d                forward.Delivery
+		j                forward.Job
+	)
+	err := s.db.QueryRowContext(ctx, `UPDATE deliveries SET
+		status = $1, locked_until = now() + $2 * interval '1 second', updated_at = now()
+		WHERE id = (SELECT id FROM deliveries d
+			WHERE ((status = $3 AND (not_before IS NULL OR not_before <= now()))
+				OR (status = $1 AND locked_until < now()))
+			AND (order_key = '' OR NOT EXISTS (SELECT 1 FROM deliveries p
+				WHERE p.order_key = d.order_key AND p.status IN ($3, $1)
+				AND (p.created_at, p.id) < (d.created_at, d.id)))
+			ORDER BY created_at, id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, method, url, headers, body, remote_addr, targets`,
+		string(storage.StatusProcessing), s.lease.Seconds(), string(storage.StatusQueued)).Scan(
+		&d.ID, &d.Method, &rawURL, &headers, &d.Body, &d.RemoteAddr, &targets)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.URL, err = url.Parse(rawURL); err != nil {
+		return nil, err
+	}
+	d.Header = http.Header{}
+	if err := json.Unmarshal(headers, &d.Header); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(targets, &j.Targets); err != nil {
+		return nil, err
+	}
+	j.Delivery = d.Copy()
+	return &j, nil
+}
+
+// Renew extends the lease of the claimed delivery
+func (s *store) Renew(j *forward.Job) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `UPDATE deliveries SET
+		locked_until = now() + $2 * interval '1 second'
+		WHERE id = $1 AND status = $3`,
+		j.Delivery.ID, s.lease.Seconds(), string(storage.StatusProcessing))
+	return err
+}
+
+// Done records the status and appends the attempts of the delivery
+func (s *store) Done(j *forward.Job, res *forward.Result) error {
+	attempts, err := json.Marshal(nonNilAttempts(j.Delivery.Attempts()))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err = s.db.ExecContext(ctx, `UPDATE deliveries SET
+		status = $2, attempts = attempts || $3::jsonb,
+		locked_until = NULL, updated_at = now() WHERE id = $1`,
+		j.Delivery.ID, string(storage.StatusOf(res)), attempts)
+	return err
+}
+
+func (s *store) Len() (int, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	var n int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT count(*) FROM deliveries WHERE status = $1`,
+		string(storage.StatusQueued)).Scan(&n)
+	return n, err
+}
+
+func (s *store) Close() error {
+	close(s.closed)
+	return s.db.Close()
+}
+
+// nonNilAttempts avoids storing null for no attempts
+func nonNilAttempts(a []forward.Attempt) []forward.Attempt {
+	if a == nil {
+		return []forward.Attempt{}
+	}
+	return a
+}
+
+func (s *store) RoutingTable() ([]byte, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	var doc []byte
+	err := s.db.QueryRowContext(ctx,
+		`SELECT document FROM routing_table`).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return nil, routing.ErrNoTable
+	}
+	return doc, err
+}
+
+func (s *store) SaveRoutingTable(doc []byte) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO routing_table (document)
+		VALUES ($1) ON CONFLICT (id) DO UPDATE SET
+		document = EXCLUDED.document, updated_at = now()`, doc)
+	return err
+}
@@ -0,0 +1,292 @@
+package postgres
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/resource"
+	"github.com/fabric8-services/fabric8-webhook/configuration"
+	"github.com/fabric8-services/fabric8-webhook/deadletter"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+	"github.com/fabric8-services/fabric8-webhook/storage"
+)
+
+// testConfiguration shortens the lease to test reclaiming deliveries
+type testConfiguration struct {
+	*configuration.Config
+	lease time.Duration
+}
+
+func (c *testConfiguration) GetForwardQueueLease() time.Duration {
+	return c.lease
+}
+
+// newStore connects to the postgres configured with the
+// F8_POSTGRES_* variables and empties the deliveries
+func newStore(t *testing.T, lease time.Duration) *store {
+	resource.Require(t, resource.Database)
+	config, err := configuration.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(&testConfiguration{Config: config, lease: lease})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.(*store).db.Exec(`DELETE FROM deliveries`); err != nil {
+		t.Fatal(err)
+	}
+	return s.(*store)
+}
+
+func newDelivery() *forward.Delivery {
+	req := httptest.NewRequest("POST", "/api/webhook?a=1", nil)
+	req.Header.Set("X-GitHub-Event", "push")
+	return forward.NewDelivery(req, []byte(`{"ref":"refs/heads/master"}`))
+}
+
+func Test_migrate(t *testing.T) {
+	s := newStore(t, time.Minute)
+	defer s.Close()
+	ctx, cancel := s.context()
+	defer cancel()
+	// already migrated by New
+	if err := migrate(ctx, s.db); err != nil {
+		t.Fatalf("migrate() error = %v", err)
+	}
+	var version int
+	if err := s.db.QueryRow(`SELECT max(version) FROM schema_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations)-1 {
+		t.Errorf("schema version = %d, want %d", version, len(migrations)-1)
+	}
+}
+
+func Test_store_Save(t *testing.T) {
+	s := newStore(t, time.Minute)
+	defer s.Close()
+
+	d := newDelivery()
+	r := &storage.Record{Delivery: d, Provider: "github", Verified: true,
+		Status: storage.StatusUnrouted, Error: "No route matches the repository"}
+	if err := s.Save(r); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if got, err := s.Get(d.ID); err != nil || got.Status != storage.StatusUnrouted || got.Error != r.Error {
+		t.Errorf("Get() unrouted = %+v, %v", got, err)
+	}
+	r.Error = ""
+	r.Targets = []forward.Target{{URL: "http://jenkins", Credentials: forward.Credentials{User: "admin", APIToken: "secret"}}}
+	r.Policy = forward.PolicyAll
+	r.Status = storage.StatusDelivered
+	r.Attempts = []forward.Attempt{{Target: "http://jenkins", Time: time.Now().UTC(), StatusCode: 200}}
+	if err := s.Save(r); err != nil {
+		t.Fatalf("Save() again error = %v", err)
+	}
+
+	got, err := s.Get(d.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Provider != "github" || !got.Verified || got.Status != storage.StatusDelivered ||
+		got.Policy != forward.PolicyAll || len(got.Targets) != 1 || len(got.Attempts) != 1 {
+		t.Errorf("Get() = %+v", got)
+	}
+	if got.Delivery.URL.String() != d.URL.String() || string(got.Delivery.Body) != string(d.Body) ||
+		got.Delivery.Header.Get("X-GitHub-Event") != "push" {
+		t.Errorf("Get() delivery = %+v, want %+v", got.Delivery, d)
+	}
+	if got.Error != "" || got.Targets[0].Credentials.APIToken != "" {
+		t.Errorf("Get() targets = %+v, want them stored without secrets", got.Targets)
+	}
+	if _, err := s.Get(forward.NewID()); err != storage.ErrNotFound {
+		t.Errorf("Get() unknown error = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func Test_store_Queue(t *testing.T) {
+	s := newStore(t, time.Minute)
+	defer s.Close()
+
+	first, second := newDelivery(), newDelivery()
+	for _, d := range []*forward.Delivery{first, second} {
+		if err := s.Push(&forward.Job{Delivery: d, Targets: []forward.Target{{URL: "http://jenkins"}}}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	}
+	if n, _ := s.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+
+	// claimed deliveries are skipped by the other workers
+	a, err := s.Pop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Pop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Delivery.ID != first.ID || b.Delivery.ID != second.ID {
+		t.Errorf("Pop() = %s, %s, want %s, %s", a.Delivery.ID, b.Delivery.ID, first.ID, second.ID)
+	}
+	if j, err := s.claim(); j != nil || err != nil {
+		t.Errorf("claim() on empty queue = %v, %v", j, err)
+	}
+
+	res := &forward.Result{Outcomes: []*forward.Outcome{{Response: &forward.Response{StatusCode: http.StatusOK}}}}
+	if err := s.Done(a, res); err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+	if got, _ := s.Get(first.ID); got.Status != storage.StatusDelivered {
+		t.Errorf("status after Done() = %v, want %v", got.Status, storage.StatusDelivered)
+	}
+}
+
+func Test_store_QueueFull(t *testing.T) {
+	s := newStore(t, time.Minute)
+	defer s.Close()
+	s.size = 1
+	if err := s.Push(&forward.Job{Delivery: newDelivery()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Push(&forward.Job{Delivery: newDelivery()}); err != forward.ErrQueueFull {
+		t.Errorf("Push() on full queue error = %v, want %v", err, forward.ErrQueueFull)
+	}
+}
+
+func Test_store_LeaseExpired(t *testing.T) {
+	s := newStore(t, 0)
+	defer s.Close()
+	d := newDelivery()
+	if err := s.Push(&forward.Job{Delivery: d}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	// the worker died, its lease expired immediately
+	j, err := s.claim()
+	if err != nil || j == nil || j.Delivery.ID != d.ID {
+		t.Errorf("claim() after expired lease = %v, %v", j, err)
+	}
+}
+
+func Test_store_NotBefore(t *testing.T) {
+	s := newStore(t, time.Minute)
+	defer s.Close()
+	later, due := newDelivery(), newDelivery()
+	if err := s.Push(&forward.Job{Delivery: later, NotBefore: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := s.claim(); err != nil || j != nil {
+		t.Errorf("claim() before NotBefore = %v, %v, want none", j, err)
+	}
+	if err := s.Push(&forward.Job{Delivery: due}); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := s.claim(); err != nil || j == nil || j.Delivery.ID != due.ID {
+		t.Errorf("claim() = %v, %v, want %s", j, err, due.ID)
+	}
+	// superseded while waiting for its debounce window
+	if err := s.Done(&forward.Job{Delivery: later}, &forward.Result{CoalescedInto: due.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Get(later.ID); got.Status != storage.StatusCoalesced {
+		t.Errorf("status after Done() = %v, want %v", got.Status, storage.StatusCoalesced)
+	}
+	if n, _ := s.Len(); n != 0 {
+		t.Errorf("Len() = %d, want 0", n)
+	}
+}
+
+func Test_store_Order(t *testing.T) {
+	s := newStore(t, time.Minute)
+	defer s.Close()
+	ordered := func(repo string) []forward.Target {
+		return []forward.Target{{URL: "http://jenkins", Ordering: forward.OrderRepository,
+			Event: forward.Event{GitURL: "https://github.com/org/" + repo}}}
+	}
+	push, del, other, unordered := newDelivery(), newDelivery(), newDelivery(), newDelivery()
+	for _, j := range []*forward.Job{
+		{Delivery: push, Targets: ordered("app")},
+		{Delivery: del, Targets: ordered("app")},
+		{Delivery: other, Targets: ordered("lib")},
+		{Delivery: unordered},
+	} {
+		if err := s.Push(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the delete waits for the push, whichever replica claimed it
+	for _, want := range []*forward.Delivery{push, other, unordered, nil} {
+		j, err := s.claim()
+		if err != nil || (j == nil) != (want == nil) || j != nil && j.Delivery.ID != want.ID {
+			t.Fatalf("claim() = %v, %v, want %v", j, err, want)
+		}
+	}
+	if err := s.Done(&forward.Job{Delivery: push}, &forward.Result{}); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := s.claim(); err != nil || j == nil || j.Delivery.ID != del.ID {
+		t.Errorf("claim() once the push is done = %v, %v, want %s", j, err, del.ID)
+	}
+}
+
+func Test_deadLetters(t *testing.T) {
+	s := newStore(t, time.Minute)
+	defer s.Close()
+	if _, err := s.db.Exec(`DELETE FROM dead_letters`); err != nil {
+		t.Fatal(err)
+	}
+	dl := s.DeadLetters()
+
+	d := newDelivery()
+	failed := &forward.Outcome{Target: "http://a", Response: &forward.Response{StatusCode: 503}}
+	for _, target := range []string{"http://a", "http://b"} {
+		if err := dl.Add(d, forward.Target{URL: target}, failed); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	all, err := dl.List("")
+	if err != nil || len(all) != 2 {
+		t.Fatalf("List() = %d letters, %v, want 2", len(all), err)
+	}
+	a, err := dl.List("http://a")
+	if err != nil || len(a) != 1 || a[0].Delivery.ID != d.ID || string(a[0].Delivery.Body) != string(d.Body) {
+		t.Fatalf("List(http://a) = %v, %v", a, err)
+	}
+	if _, err := dl.Get(a[0].ID); err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+	if err := dl.Remove(a[0].ID); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if err := dl.Remove(a[0].ID); err != deadletter.ErrNotFound {
+		t.Errorf("Remove() twice error = %v, want %v", err, deadletter.ErrNotFound)
+	}
+}
+
+func Test_store_RoutingTable(t *testing.T) {
+	s := newStore(t, time.Minute)
+	defer s.Close()
+	if _, err := s.db.Exec(`DELETE FROM routing_table`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RoutingTable(); err != routing.ErrNoTable {
+		t.Errorf("RoutingTable() error = %v, want %v", err, routing.ErrNoTable)
+	}
+	for _, doc := range []string{"default: http://a\n", "default: http://b\n"} {
+		if err := s.SaveRoutingTable([]byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.RoutingTable()
+		if err != nil || string(got) != doc {
+			t.Errorf("RoutingTable() = %s, %v, want %s", got, err, doc)
+		}
+	}
+}
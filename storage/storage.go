@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fabric8-services/fabric8-webhook/deadletter"
+	"github.com/fabric8-services/fabric8-webhook/forward"
+	"github.com/fabric8-services/fabric8-webhook/routing"
+)
+
+// ErrNotFound is returned for unknown deliveries
+var ErrNotFound = errors.New("Delivery not found")
+
+// Status of a recorded delivery
+type Status string
+
+const (
+	// StatusRejected is a delivery which failed verification
+	StatusRejected Status = "rejected"
+	// StatusReceived is a verified delivery not forwarded yet
+	StatusReceived Status = "received"
+	// StatusUnrouted is a verified delivery which could not be
+	// parsed or has no target, Error tells why
+	StatusUnrouted Status = "unrouted"
+	// StatusQueued is a delivery waiting for a worker
+	StatusQueued Status = "queued"
+	// StatusProcessing is a delivery claimed by a worker
+	StatusProcessing Status = "processing"
+	// StatusDelivered is a delivery every target accepted
+	StatusDelivered Status = "delivered"
+	// StatusFailed is a delivery at least one target did not accept
+	StatusFailed Status = "failed"
+	// StatusCoalesced is a push superseded by a later push to the
+	// same repository and ref within the debounce window
+	StatusCoalesced Status = "coalesced"
+)
+
+// Record is a delivery with its verification result,
+// routing decision and forwarding attempts
+type Record struct {
+	Delivery *forward.Delivery
+	// Provider is the name of the provider which verified the delivery
+	Provider string
+	Verified bool
+	// Targets and Policy are the routing decision, the
+	// targets are stored without their secrets
+	Targets []forward.Target
+	Policy  forward.Policy
+	Status  Status
+	// Error is why an unrouted delivery could not be routed
+	Error    string
+	Attempts []forward.Attempt
+	Created  time.Time
+	Updated  time.Time
+}
+
+// StatusOf returns the status of a forwarded delivery
+func StatusOf(res *forward.Result) Status {
+	if res.CoalescedInto != "" {
+		return StatusCoalesced
+	}
+	for _, o := range res.Outcomes {
+		if !o.Succeeded() {
+			return StatusFailed
+		}
+	}
+	return StatusDelivered
+}
+
+// RedactTargets returns the targets without their secrets, never nil
+func RedactTargets(targets []forward.Target) []forward.Target {
+	redacted := []forward.Target{}
+	for _, t := range targets {
+		redacted = append(redacted, t.Redacted())
+	}
+	return redacted
+}
+
+// Store records deliveries, is the durable queue of asynchronous
+// forwarding and keeps the dead letters and the routing table
+type Store interface {
+	forward.Queue
+	routing.TableStore
+	// Save records the delivery, replacing a previous record
+	Save(r *Record) error
+	Get(id string) (*Record, error)
+	DeadLetters() deadletter.Store
+	Close() error
+}
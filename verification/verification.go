@@ -1,16 +1,27 @@
 package verification
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/goadesign/goa"
 
+	"github.com/fabric8-services/fabric8-webhook/configuration"
 	"github.com/fabric8-services/fabric8-webhook/util"
 )
 
@@ -18,6 +29,7 @@ const (
 	meta = "https://api.github.com/meta"
 )
 
+// service verifies deliveries of a single GitHub instance
 type service struct {
 	// IP address ranges specifying incoming webhooks
 	// and service hookIPs that originates from on GitHub.com
@@ -26,28 +38,189 @@ type service struct {
 	lock    sync.RWMutex
 	Service *goa.Service
 	ticker  *time.Ticker
+	// meta is the URL to fetch hookIPs from
+	meta string
+	// client to make requests to the instance, util.NetClient if nil
+	client *http.Client
+	// secrets to verify the signature of deliveries
+	secrets []string
+}
+
+// instances dispatches verification to the
+// GitHub instance a delivery originates from
+type instances struct {
+	github *service
+	// enterprise GitHub instances by host
+	enterprise map[string]*service
+	Service    *goa.Service
 }
 
 // Service defines verification
 type Service interface {
-	Verify(req *http.Request) (bool, error)
+	Verify(req *http.Request, body []byte) (bool, error)
+}
+
+// New returns a verification service instance for github.com
+// and the configured GitHub Enterprise Server instances
+func New(gs *goa.Service, duration time.Duration,
+	ghInstances []configuration.GitHubInstance) (Service, error) {
+	s := &instances{
+		github: &service{
+			Service: gs,
+			ticker:  time.NewTicker(duration),
+			meta:    meta,
+		},
+		enterprise: map[string]*service{},
+		Service:    gs,
+	}
+	for _, ghi := range ghInstances {
+		i, err := newInstance(gs, duration, ghi)
+		if err != nil {
+			s.stop()
+			return nil, err
+		}
+		host := normalizeHost(ghi.Host)
+		if host == "github.com" {
+			s.github.ticker.Stop()
+			s.github = i
+			continue
+		}
+		s.enterprise[host] = i
+	}
+
+	for _, i := range s.all() {
+		if err := i.setHookIPs(); err != nil {
+			if len(i.secrets) == 0 {
+				s.stop()
+				return nil, err
+			}
+			// retried on every delivery from an unknown IP
+			gs.LogError("Instance verified by signature only until its hook IPs are fetched",
+				"meta", i.meta, "err", err)
+		}
+	}
+	for _, i := range s.all() {
+		go i.monitor()
+	}
+	return s, nil
 }
 
-// New returns a verification service instance
-func New(gs *goa.Service, duration time.Duration) (Service, error) {
+func (s *instances) all() []*service {
+	all := []*service{s.github}
+	for _, i := range s.enterprise {
+		all = append(all, i)
+	}
+	return all
+}
+
+// stop stops refreshing hookIPs of all instances
+func (s *instances) stop() {
+	for _, i := range s.all() {
+		i.ticker.Stop()
+	}
+}
+
+func newInstance(gs *goa.Service, duration time.Duration,
+	ghi configuration.GitHubInstance) (*service, error) {
+	host := normalizeHost(ghi.Host)
+	if host == "" {
+		return nil, errors.New("GitHub instance without host")
+	}
+	apiURL := ghi.APIURL
+	if apiURL == "" {
+		apiURL = "https://" + host + "/api/v3"
+	}
+	metaURL := ghi.MetaURL
+	switch {
+	case metaURL != "":
+	case ghi.APIURL == "" && host == "github.com":
+		// github.com serves its API from api.github.com
+		metaURL = meta
+	default:
+		metaURL = strings.TrimSuffix(apiURL, "/") + "/meta"
+	}
 	s := &service{
 		Service: gs,
 		ticker:  time.NewTicker(duration),
+		meta:    metaURL,
+		secrets: ghi.Secrets,
 	}
-	if err := s.setHookIPs(); err != nil {
-		return nil, err
+	if ghi.CAFile != "" {
+		pem, err := ioutil.ReadFile(ghi.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in " + ghi.CAFile)
+		}
+		s.client = &http.Client{
+			Timeout: util.NetClient.Timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
 	}
 	return s, nil
 }
 
+// Verify verifies whether request came from approved source of
+// the GitHub instance identified by the X-GitHub-Enterprise-Host
+// header or the repository URL
+func (s *instances) Verify(req *http.Request, body []byte) (bool, error) {
+	if host := req.Header.Get("X-GitHub-Enterprise-Host"); host != "" {
+		i, ok := s.enterprise[normalizeHost(host)]
+		if !ok {
+			s.Service.LogInfo("Unknown GitHub Enterprise host", "host:", host)
+			return false, nil
+		}
+		return i.Verify(req, body)
+	}
+
+	repo := struct {
+		Repository struct {
+			HTMLURL string `json:"html_url"`
+		} `json:"repository"`
+	}{}
+	if err := json.Unmarshal(body, &repo); err == nil {
+		if u, err := url.Parse(repo.Repository.HTMLURL); err == nil {
+			if i, ok := s.enterprise[normalizeHost(u.Host)]; ok {
+				return i.Verify(req, body)
+			}
+		}
+	}
+	return s.github.Verify(req, body)
+}
+
+// normalizeHost lowercases host and strips its default port
+// so that the same instance is found however its host is written
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, port, err := net.SplitHostPort(host); err == nil && (port == "443" || port == "80") {
+		if strings.Contains(h, ":") {
+			return "[" + h + "]"
+		}
+		return h
+	}
+	return host
+}
+
 // Verify verifies whether request came
 // from approved source
-func (s *service) Verify(req *http.Request) (bool, error) {
+func (s *service) Verify(req *http.Request, body []byte) (bool, error) {
+	if len(s.secrets) > 0 {
+		if !s.isValidSignature(req, body) {
+			s.Service.LogInfo("Invalid signature", "meta:", s.meta)
+			return false, nil
+		}
+		// Instances which don't publish hook IPs
+		// can only be verified by signature
+		if !s.hasHookIPs() {
+			return true, nil
+		}
+	}
+
 	ip := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
 	if len(ip[0]) == 0 {
 		ip = strings.Split(req.RemoteAddr, ":")
@@ -66,13 +239,62 @@ func (s *service) Verify(req *http.Request) (bool, error) {
 	return true, nil
 }
 
+// isValidSignature checks X-Hub-Signature-256, or X-Hub-Signature
+// for older instances, against every configured secret
+func (s *service) isValidSignature(req *http.Request, body []byte) bool {
+	prefix, newHash := "sha256=", sha256.New
+	sig := req.Header.Get("X-Hub-Signature-256")
+	if sig == "" {
+		prefix, newHash = "sha1=", sha1.New
+		sig = req.Header.Get("X-Hub-Signature")
+	}
+	if !strings.HasPrefix(sig, prefix) {
+		return false
+	}
+	sig = strings.TrimPrefix(sig, prefix)
+	for _, secret := range s.secrets {
+		if validSignature(newHash, secret, sig, body) {
+			return true
+		}
+	}
+	return false
+}
+
+func validSignature(newHash func() hash.Hash, secret, sig string, body []byte) bool {
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
+
+// monitor refreshes hookIPs on every tick of the ticker
+func (s *service) monitor() {
+	for range s.ticker.C {
+		if err := s.setHookIPs(); err != nil {
+			s.Service.LogError("Error while refreshing"+
+				" hookips", "meta", s.meta, "err", err)
+		}
+	}
+}
+
+func (s *service) netClient() *http.Client {
+	if s.client != nil {
+		return s.client
+	}
+	return util.NetClient
+}
+
 func (s *service) setHookIPs() error {
-	res, err := util.NetClient.Get(meta)
+	res, err := s.netClient().Get(s.meta)
 	if err != nil {
 		s.Service.LogError("Error while making request to github:",
 			"err", err)
 		return err
 	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", s.meta, res.Status)
+	}
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		s.Service.LogError("Error while reading response body"+
@@ -98,12 +320,22 @@ func (s *service) setHookIPs() error {
 		}
 		ipnets = append(ipnets, ipnet)
 	}
+	// the hook IPs fetched before are kept
+	if len(ipnets) == 0 {
+		return fmt.Errorf("%s has no hooks", s.meta)
+	}
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.hookIPs = ipnets
 	return nil
 }
 
+func (s *service) hasHookIPs() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.hookIPs) > 0
+}
+
 func (s *service) isGithubIP(i string) bool {
 	ip := net.ParseIP(i)
 	s.lock.RLock()
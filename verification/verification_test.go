@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/fabric8-services/fabric8-webhook/configuration"
 	"github.com/fabric8-services/fabric8-webhook/util"
 	"github.com/goadesign/goa"
 	goalogrus "github.com/goadesign/goa/logging/logrus"
@@ -59,10 +60,15 @@ func TestNew(t *testing.T) {
 				}),
 			},
 			args: args{15 * time.Minute},
-			want: Service(&service{
-				hookIPs: nil,
-				Service: gs,
-				ticker:  time.NewTicker(15 * time.Minute),
+			want: Service(&instances{
+				github: &service{
+					hookIPs: nil,
+					Service: gs,
+					ticker:  time.NewTicker(15 * time.Minute),
+					meta:    meta,
+				},
+				enterprise: map[string]*service{},
+				Service:    gs,
 			}),
 			wantHookIPs: []string{(&net.IPNet{IP: net.IPv4(192, 30, 252, 0), Mask: net.IPv4Mask(255, 255, 252, 0)}).String(), (&net.IPNet{IP: net.IPv4(185, 199, 108, 0), Mask: net.IPv4Mask(255, 255, 252, 0)}).String(), (&net.IPNet{IP: net.IPv4(140, 82, 112, 0), Mask: net.IPv4Mask(255, 255, 240, 0)}).String()},
 			wantErr:     false,
@@ -88,7 +94,7 @@ func TestNew(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			util.SetMockNetClient(tt.fields.clientTransport)
-			got, err := New(gs, tt.args.duration)
+			got, err := New(gs, tt.args.duration, nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -96,13 +102,15 @@ func TestNew(t *testing.T) {
 
 			var hookIPs []string
 			if err == nil {
-				for _, ipnet := range got.(*service).hookIPs {
+				gh := got.(*instances).github
+				for _, ipnet := range gh.hookIPs {
 					hookIPs = append(hookIPs, ipnet.String())
 				}
 				// Changing s.HookIPs and ticker as reflect.Deepequal doesn't work for IPNet
-				got.(*service).hookIPs = tt.want.(*service).hookIPs
-				got.(*service).ticker = tt.want.(*service).ticker
-				got.(*service).ticker.Stop()
+				gh.ticker.Stop()
+				gh.hookIPs = tt.want.(*instances).github.hookIPs
+				tt.want.(*instances).github.ticker.Stop()
+				tt.want.(*instances).github.ticker = gh.ticker
 			}
 
 			if !reflect.DeepEqual(got, tt.want) ||
@@ -175,7 +183,7 @@ func Test_service_Verify(t *testing.T) {
 				hookIPs: tt.fields.hooks,
 				Service: gs,
 			}
-			got, err := s.Verify(tt.args.req)
+			got, err := s.Verify(tt.args.req, nil)
 			if got != tt.want && !tt.wantErr {
 				t.Errorf("service.Verify() = %v, want %v", got, tt.want)
 			}
@@ -311,6 +319,33 @@ func Test_service_setHooks(t *testing.T) {
 			want:    nil,
 			wantErr: true,
 		},
+		{
+			name: "setHookIPs Verification Test Negative - 5 Status",
+			fields: fields{
+				clientTransport: util.RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: 503,
+						Status:     "503 Service Unavailable",
+						Body:       ioutil.NopCloser(bytes.NewBufferString(`{"hooks": ["192.30.252.0/22"]}`)),
+					}, nil
+				}),
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "setHookIPs Verification Test Negative - 6 No Hooks",
+			fields: fields{
+				clientTransport: util.RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: 200,
+						Body:       ioutil.NopCloser(bytes.NewBufferString(`{"message": "rate limited"}`)),
+					}, nil
+				}),
+			},
+			want:    nil,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -381,3 +416,167 @@ func Test_service_isGithubIP(t *testing.T) {
 		})
 	}
 }
+
+func Test_instances_Verify(t *testing.T) {
+	body := []byte(`{"repository":{"html_url":"https://ghe.example.com/org/repo"}}`)
+	type args struct {
+		header     http.Header
+		remoteAddr string
+		body       []byte
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			name: "Verify Enterprise Host Header Positive",
+			args: args{
+				header: http.Header{
+					"X-Github-Enterprise-Host": {"ghe.example.com"},
+					"X-Hub-Signature-256":      {"sha256=41f5b14181c2867da0de442c025fef358286f24a48ce01307386859437a91878"},
+				},
+				remoteAddr: "10.0.0.1:8080",
+				body:       body,
+			},
+			want: true,
+		},
+		{
+			name: "Verify Enterprise Repository URL SHA1 Positive",
+			args: args{
+				header: http.Header{
+					"X-Hub-Signature": {"sha1=b01c8d8a32b8aacb7db4dc8b459aeedb26294fbb"},
+				},
+				remoteAddr: "10.0.0.1:8080",
+				body:       body,
+			},
+			want: true,
+		},
+		{
+			name: "Verify Enterprise Invalid Signature Negative",
+			args: args{
+				header: http.Header{
+					"X-Github-Enterprise-Host": {"ghe.example.com"},
+					"X-Hub-Signature-256":      {"sha256=0000"},
+				},
+				remoteAddr: "10.0.0.1:8080",
+				body:       body,
+			},
+			want: false,
+		},
+		{
+			name: "Verify Enterprise Host Header Case And Port Positive",
+			args: args{
+				header: http.Header{
+					"X-Github-Enterprise-Host": {"GHE.Example.com:443"},
+					"X-Hub-Signature-256":      {"sha256=41f5b14181c2867da0de442c025fef358286f24a48ce01307386859437a91878"},
+				},
+				remoteAddr: "10.0.0.1:8080",
+				body:       body,
+			},
+			want: true,
+		},
+		{
+			name: "Verify Enterprise Repository URL Case And Port Positive",
+			args: args{
+				header: http.Header{
+					"X-Hub-Signature-256": {"sha256=07956d549aef20a2ce84e787314b9a7803110016c05664d68b2d2a9791c75c32"},
+				},
+				remoteAddr: "10.0.0.1:8080",
+				body:       []byte(`{"repository":{"html_url":"https://GHE.example.com:443/org/repo"}}`),
+			},
+			want: true,
+		},
+		{
+			name: "Verify Unknown Enterprise Host Negative",
+			args: args{
+				header: http.Header{
+					"X-Github-Enterprise-Host": {"other.example.com"},
+				},
+				remoteAddr: "192.30.252.1:8080",
+				body:       body,
+			},
+			want: false,
+		},
+		{
+			name: "Verify GitHub Fallback Positive",
+			args: args{
+				header:     http.Header{},
+				remoteAddr: "192.30.252.1:8080",
+				body:       []byte(`{"repository":{"html_url":"https://github.com/org/repo"}}`),
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &instances{
+				github: &service{
+					hookIPs: []*net.IPNet{{IP: net.IPv4(192, 30, 252, 0), Mask: net.IPv4Mask(255, 255, 252, 0)}},
+					Service: gs,
+				},
+				enterprise: map[string]*service{
+					"ghe.example.com": {
+						Service: gs,
+						secrets: []string{"old", "secret"},
+					},
+				},
+				Service: gs,
+			}
+			req := &http.Request{Header: tt.args.header, RemoteAddr: tt.args.remoteAddr}
+			got, err := s.Verify(req, tt.args.body)
+			if err != nil {
+				t.Errorf("instances.Verify() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("instances.Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_normalizeHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "ghe.example.com", want: "ghe.example.com"},
+		{host: "GHE.Example.COM", want: "ghe.example.com"},
+		{host: "ghe.example.com:443", want: "ghe.example.com"},
+		{host: "ghe.example.com:80", want: "ghe.example.com"},
+		{host: "ghe.example.com:8443", want: "ghe.example.com:8443"},
+		{host: "[::1]:443", want: "[::1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := normalizeHost(tt.host); got != tt.want {
+				t.Errorf("normalizeHost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newInstance(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance configuration.GitHubInstance
+		want     string
+	}{
+		{name: "GitHub", instance: configuration.GitHubInstance{Host: "github.com"}, want: meta},
+		{name: "Enterprise", instance: configuration.GitHubInstance{Host: "ghe.example.com"}, want: "https://ghe.example.com/api/v3/meta"},
+		{name: "API URL", instance: configuration.GitHubInstance{Host: "ghe.example.com", APIURL: "https://api.ghe.example.com/"}, want: "https://api.ghe.example.com/meta"},
+		{name: "Meta URL", instance: configuration.GitHubInstance{Host: "github.com", MetaURL: "https://meta.example.com"}, want: "https://meta.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newInstance(gs, time.Minute, tt.instance)
+			if err != nil {
+				t.Fatalf("newInstance() error = %v", err)
+			}
+			got.ticker.Stop()
+			if got.meta != tt.want {
+				t.Errorf("newInstance() meta = %v, want %v", got.meta, tt.want)
+			}
+		})
+	}
+}